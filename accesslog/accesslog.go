@@ -0,0 +1,267 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package accesslog implements HTTP access logging middleware.
+//
+// Each request served by a Logger is described by an Entry, which is
+// written to the log in one of several formats (see Format) once the
+// response is complete.  Handlers such as frontend.Endpoint add details
+// about the backend to the Entry (see FromRequest).
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kylelemons.net/go/daemon"
+)
+
+// An Entry describes a request for the access log.
+type Entry struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"` // IP of the client
+	User       string        `json:"user,omitempty"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"` // request URI (path and query)
+	Proto      string        `json:"proto"`
+	Scheme     string        `json:"scheme"`
+	Host       string        `json:"host"`
+	Status     int           `json:"status"`
+	BytesIn    int64         `json:"bytes_in"`
+	BytesOut   int64         `json:"bytes_out"`
+	Duration   time.Duration `json:"duration"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	RequestID  string        `json:"request_id,omitempty"`
+	TLSVersion string        `json:"tls_version,omitempty"`
+
+	// Filled in by the handler which proxies the request, if any
+	Backend     string        `json:"backend,omitempty"`      // name of the backend
+	BackendHost string        `json:"backend_host,omitempty"` // host chosen to serve it
+	Upstream    time.Duration `json:"upstream,omitempty"`     // time until the backend responded
+
+	req  *http.Request
+	resp http.Header
+}
+
+// URL returns the full URL requested by the client.
+func (e *Entry) URL() string {
+	if e.Host == "" {
+		return e.URI
+	}
+	return e.Scheme + "://" + e.Host + e.URI
+}
+
+// Path returns the path requested by the client (without the query).
+func (e *Entry) Path() string {
+	if i := strings.Index(e.URI, "?"); i >= 0 {
+		return e.URI[:i]
+	}
+	return e.URI
+}
+
+// SetBackend records the backend which served the request and how long it
+// took to respond.  It does nothing if e is nil, so it can be called
+// with the result of FromRequest without checking it.
+func (e *Entry) SetBackend(name, host string, upstream time.Duration) {
+	if e == nil {
+		return
+	}
+	e.Backend, e.BackendHost, e.Upstream = name, host, upstream
+}
+
+// SetUser records the name of the authenticated user.  Like SetBackend,
+// it does nothing if e is nil.
+func (e *Entry) SetUser(user string) {
+	if e == nil {
+		return
+	}
+	e.User = user
+}
+
+type entryKey struct{}
+
+// FromRequest returns the Entry for a request being served by a Logger,
+// or nil if there is none.
+func FromRequest(r *http.Request) *Entry {
+	e, _ := r.Context().Value(entryKey{}).(*Entry)
+	return e
+}
+
+// tlsVersions are the names of TLS versions for the log.
+var tlsVersions = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLSv1.0",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// newEntry returns an Entry describing the request as it arrived.
+func newEntry(r *http.Request, w http.ResponseWriter) *Entry {
+	e := &Entry{
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.URL.RequestURI(),
+		Proto:      r.Proto,
+		Scheme:     "http",
+		Host:       r.Host,
+		Status:     http.StatusOK,
+		Referer:    r.Header.Get("Referer"),
+		UserAgent:  r.Header.Get("User-Agent"),
+		req:        r,
+		resp:       w.Header(),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e.RemoteAddr = host
+	}
+	if r.TLS != nil {
+		e.Scheme = "https"
+		if e.TLSVersion = tlsVersions[r.TLS.Version]; e.TLSVersion == "" {
+			e.TLSVersion = fmt.Sprintf("0x%04x", r.TLS.Version)
+		}
+	}
+	return e
+}
+
+// finish fills in the parts of the entry which are only known once the
+// request has been served.
+func (e *Entry) finish() {
+	e.Duration = time.Since(e.Time)
+	if e.RequestID = e.resp.Get("X-Request-Id"); e.RequestID == "" {
+		e.RequestID = e.req.Header.Get("X-Request-Id")
+	}
+}
+
+// A Logger writes an access log.  Its Wrap method can be used as
+// middleware (for example, with frontend.Frontend.Use).
+type Logger struct {
+	Format Format
+
+	mu  sync.Mutex
+	out io.Writer
+	buf bytes.Buffer
+}
+
+// New returns a Logger which writes entries to w in the given format.
+// If f is nil, Common is used.
+func New(w io.Writer, f Format) *Logger {
+	if f == nil {
+		f = Common
+	}
+	return &Logger{
+		Format: f,
+		out:    w,
+	}
+}
+
+// Log writes an entry to the log.
+func (l *Logger) Log(e *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Reset()
+	l.Format.Format(&l.buf, e)
+	l.buf.WriteByte('\n')
+	if _, err := l.out.Write(l.buf.Bytes()); err != nil {
+		daemon.Error.Printf("access log: %s", err)
+	}
+}
+
+// Wrap returns a handler which serves h and logs each request.
+func (l *Logger) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := newEntry(r, w)
+		r = r.WithContext(context.WithValue(r.Context(), entryKey{}, e))
+		e.req = r
+		if r.Body != nil {
+			body := &countingBody{ReadCloser: r.Body, n: &e.BytesIn}
+			r.Body = body
+		}
+		defer func() {
+			e.finish()
+			l.Log(e)
+		}()
+		h.ServeHTTP(&countingWriter{ResponseWriter: w, e: e}, r)
+	})
+}
+
+// A countingWriter records the status and size of the response.
+type countingWriter struct {
+	http.ResponseWriter
+	e     *Entry
+	wrote bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.e.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.e.BytesOut += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wrote = true
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, if the underlying ResponseWriter
+// supports it.  Unless a status was written, the request is logged with
+// 101 Switching Protocols; nothing written to the connection is counted.
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", w.ResponseWriter)
+	}
+	conn, buf, err := h.Hijack()
+	if err == nil && !w.wrote {
+		w.wrote = true
+		w.e.Status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// A countingBody records the size of the request body.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	*b.n += int64(n)
+	return n, err
+}
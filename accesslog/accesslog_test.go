@@ -0,0 +1,213 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve serves a test request with a Logger using the given format
+// and returns the log line.
+func serve(t *testing.T, format Format) string {
+	var buf bytes.Buffer
+	l := New(&buf, format)
+
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		FromRequest(r).SetUser("alice")
+		FromRequest(r).SetBackend("blog", "localhost:8001", 1500*time.Microsecond)
+		w.Header().Set("X-Request-Id", "abc123")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not here"))
+	}))
+
+	r, _ := http.NewRequest("POST", "https://example.com/path?q=1", strings.NewReader("body"))
+	r.RequestURI = "/path?q=1"
+	r.RemoteAddr = "1.2.3.4:5678"
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS12}
+	r.Header.Set("User-Agent", `Mozilla "quoted"`)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	if !strings.HasSuffix(line, "\n") {
+		t.Errorf("log line %q does not end with a newline", line)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		desc   string
+		format string
+		want   string // with the timestamp removed and {D} for the duration
+	}{
+		{
+			desc:   "common",
+			format: CommonFormat,
+			want:   `1.2.3.4 - alice  "POST /path?q=1 HTTP/1.1" 404 8`,
+		},
+		{
+			desc:   "combined",
+			format: CombinedFormat,
+			want:   `1.2.3.4 - alice  "POST /path?q=1 HTTP/1.1" 404 8 "-" "Mozilla \"quoted\""`,
+		},
+		{
+			desc:   "gofr",
+			format: GofrFormat,
+			want:   `1.2.3.4 - alice  "POST /path?q=1 HTTP/1.1" 404 8 "https://example.com/path?q=1" "Mozilla \"quoted\"" abc123`,
+		},
+		{
+			desc:   "gofr-extended",
+			format: GofrExtendedFormat,
+			want:   `1.2.3.4 - alice  "POST /path?q=1 HTTP/1.1" 404 8 "https://example.com/path?q=1" "Mozilla \"quoted\"" abc123 blog {D} 1500`,
+		},
+		{
+			desc:   "custom",
+			format: `%m %U%q %v %I %O %{backend}x@%{backend_host}x %{upstream}x %{tls}x %{X-Request-Id}o 100%%`,
+			want:   `POST /path?q=1 example.com 4 8 blog@localhost:8001 1500 TLSv1.2 abc123 100%`,
+		},
+	}
+
+	for _, test := range tests {
+		f, err := Parse(test.format)
+		if err != nil {
+			t.Errorf("%s: Parse(%q): %s", test.desc, test.format, err)
+			continue
+		}
+		got := serve(t, f)
+		if start, end := strings.Index(got, "["), strings.Index(got, "]"); start >= 0 && end > start {
+			got = got[:start] + got[end+1:]
+		}
+		if parts := strings.SplitN(test.want, "{D}", 2); len(parts) == 2 && strings.HasPrefix(got, parts[0]) && strings.HasSuffix(got, parts[1]) {
+			d := got[len(parts[0]) : len(got)-len(parts[1])]
+			if _, err := strconv.Atoi(d); err == nil {
+				got = parts[0] + "{D}" + parts[1]
+			}
+		}
+		if got != test.want {
+			t.Errorf("%s: got  %s", test.desc, got)
+			t.Errorf("%s: want %s", test.desc, test.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var e Entry
+	line := serve(t, JSON)
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		t.Fatalf("unmarshal %q: %s", line, err)
+	}
+	if got, want := e.URL(), "https://example.com/path?q=1"; got != want {
+		t.Errorf("url = %q, want %q", got, want)
+	}
+	if e.Status != 404 || e.BytesIn != 4 || e.BytesOut != 8 {
+		t.Errorf("status, in, out = %d, %d, %d, want 404, 4, 8", e.Status, e.BytesIn, e.BytesOut)
+	}
+	if e.Backend != "blog" || e.BackendHost != "localhost:8001" || e.Upstream != 1500*time.Microsecond {
+		t.Errorf("backend = %q %q %s, want blog localhost:8001 1.5ms", e.Backend, e.BackendHost, e.Upstream)
+	}
+	if e.RequestID != "abc123" || e.User != "alice" || e.TLSVersion != "TLSv1.2" {
+		t.Errorf("id, user, tls = %q, %q, %q, want abc123, alice, TLSv1.2", e.RequestID, e.User, e.TLSVersion)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, format := range []string{
+		"%",
+		"%{Referer",
+		"%Z",
+		"%{nope}x",
+		"%{Header}z",
+	} {
+		if _, err := Parse(format); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", format)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"common", "combined", "gofr", "gofr-extended", "json", "%h %s"} {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q): %s", name, err)
+		}
+	}
+	if _, err := Lookup("bogus"); err == nil {
+		t.Errorf("Lookup(%q) succeeded, want error", "bogus")
+	}
+}
+
+func TestFlushHijack(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, MustParse("%>s %b"))
+
+	rec := httptest.NewRecorder()
+	l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("event"))
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatalf("%T is not an http.Flusher", w)
+		}
+		f.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Errorf("response was not flushed")
+	}
+	if got, want := buf.String(), "200 5\n"; got != want {
+		t.Errorf("flushed log = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	server := httptest.NewServer(l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Errorf("%T is not an http.Hijacker", w)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %s", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got, want := string(body), "hijacked"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	server.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if got, want := buf.String(), "101 -\n"; got != want {
+		t.Errorf("hijacked log = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"kylelemons.net/go/daemon"
+)
+
+// An Overflow policy determines what an AsyncWriter does with a write
+// when its queue is full.
+type Overflow int
+
+const (
+	// Block waits for room in the queue.  This is the default.
+	Block Overflow = iota
+
+	// Drop discards the write (and counts it; see AsyncWriter.Dropped).
+	Drop
+)
+
+// DefaultQueueSize is the queue size used if none is specified.
+const DefaultQueueSize = 1024
+
+// An AsyncWriter queues writes and performs them in the background through
+// a buffer, so that callers (such as a Logger serving requests) do not wait
+// for the disk.  Each write is queued separately, so lines are never split.
+//
+// Once it is closed, an AsyncWriter writes synchronously, so that lines
+// logged during shutdown (e.g. while in lame duck mode) are not lost.
+type AsyncWriter struct {
+	overflow Overflow
+	out      io.Writer
+	buf      *bufio.Writer
+	queue    chan asyncItem
+	done     chan struct{}
+	dropped  uint64 // atomic
+
+	mu     sync.RWMutex // held for writing to close
+	closed bool
+}
+
+type asyncItem struct {
+	line    []byte
+	flushed chan struct{} // if non-nil, this is a flush request
+}
+
+// NewAsyncWriter returns an AsyncWriter which writes to w with a queue of
+// the given number of writes (DefaultQueueSize if size <= 0).
+func NewAsyncWriter(w io.Writer, size int, overflow Overflow) *AsyncWriter {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	a := &AsyncWriter{
+		overflow: overflow,
+		out:      w,
+		buf:      bufio.NewWriter(w),
+		queue:    make(chan asyncItem, size),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for item := range a.queue {
+		if item.flushed != nil {
+			a.flush()
+			close(item.flushed)
+			continue
+		}
+		if _, err := a.buf.Write(item.line); err != nil {
+			daemon.Error.Printf("access log: %s", err)
+		}
+		if len(a.queue) == 0 {
+			a.flush()
+		}
+	}
+	a.flush()
+}
+
+func (a *AsyncWriter) flush() {
+	if err := a.buf.Flush(); err != nil {
+		daemon.Error.Printf("access log: %s", err)
+		a.buf.Reset(a.out)
+	}
+}
+
+// Write queues a copy of b to be written.  It always succeeds unless
+// the AsyncWriter is closed and the underlying write fails.
+func (a *AsyncWriter) Write(b []byte) (int, error) {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.out.Write(b)
+	}
+	defer a.mu.RUnlock()
+
+	item := asyncItem{line: append([]byte(nil), b...)}
+	if a.overflow == Drop {
+		select {
+		case a.queue <- item:
+		default:
+			atomic.AddUint64(&a.dropped, 1)
+		}
+		return len(b), nil
+	}
+	a.queue <- item
+	return len(b), nil
+}
+
+// Dropped returns the number of writes which have been dropped
+// because the queue was full.
+func (a *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Flush waits until all writes queued before it have been written.
+func (a *AsyncWriter) Flush() {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return
+	}
+	flushed := make(chan struct{})
+	a.queue <- asyncItem{flushed: flushed}
+	a.mu.RUnlock()
+	<-flushed
+}
+
+// Close writes everything in the queue and stops the background writer.
+// Subsequent writes are performed synchronously.  The underlying writer
+// is not closed.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	<-a.done
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A slowWriter blocks writes until it is released.
+type slowWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan bool
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(b)
+}
+
+func (w *slowWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	out := &slowWriter{release: make(chan bool)}
+	close(out.release)
+	a := NewAsyncWriter(out, 4, Block)
+
+	line := []byte("first\n")
+	a.Write(line)
+	copy(line, "XXXXX") // the writer must have its own copy
+	fmt.Fprintf(a, "second\n")
+	a.Flush()
+	if got, want := out.String(), "first\nsecond\n"; got != want {
+		t.Errorf("after flush: %q, want %q", got, want)
+	}
+
+	a.Close()
+	fmt.Fprintf(a, "after close\n")
+	if got, want := out.String(), "first\nsecond\nafter close\n"; got != want {
+		t.Errorf("after close: %q, want %q", got, want)
+	}
+}
+
+func TestAsyncWriterDrop(t *testing.T) {
+	out := &slowWriter{release: make(chan bool)}
+	a := NewAsyncWriter(out, 2, Drop)
+
+	// With the writer stuck, at most one line is in progress and two are
+	// queued; the rest are dropped instead of blocking.
+	const lines = 10
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(a, "line %d\n", i)
+	}
+	if got := a.Dropped(); got < lines-3 {
+		t.Errorf("Dropped() = %d, want at least %d", got, lines-3)
+	}
+
+	close(out.release)
+	a.Close()
+	written := strings.Count(out.String(), "\n")
+	if got, want := uint64(written)+a.Dropped(), uint64(lines); got != want {
+		t.Errorf("written %d + dropped %d = %d, want %d", written, a.Dropped(), got, want)
+	}
+}
+
+func TestAsyncLogger(t *testing.T) {
+	var buf bytes.Buffer
+	a := NewAsyncWriter(&buf, 0, Block)
+	l := New(a, MustParse("%m %{url}x"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Log(&Entry{Method: "GET", URI: fmt.Sprintf("/%03d", i)})
+		}(i)
+	}
+	wg.Wait()
+	a.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if got, want := len(lines), 100; got != want {
+		t.Fatalf("got %d lines, want %d", got, want)
+	}
+	for _, line := range lines {
+		if len(line) != len("GET /000") || !strings.HasPrefix(line, "GET /") {
+			t.Errorf("corrupt line %q", line)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Format writes an Entry as a single line (without the newline).
+type Format interface {
+	Format(buf *bytes.Buffer, e *Entry)
+}
+
+// FormatFunc allows a function to be used as a Format.
+type FormatFunc func(buf *bytes.Buffer, e *Entry)
+
+// Format calls f(buf, e).
+func (f FormatFunc) Format(buf *bytes.Buffer, e *Entry) { f(buf, e) }
+
+// Standard formats
+var (
+	// Common is the Common Log Format.
+	Common = MustParse(CommonFormat)
+
+	// Combined is the Combined Log Format (Common with referer and user agent).
+	Combined = MustParse(CombinedFormat)
+
+	// Gofr is Combined with the full URL instead of the referer, followed by
+	// the request ID.  It is the format written by gofr by default.
+	Gofr = MustParse(GofrFormat)
+
+	// GofrExtended is Gofr followed by the backend, the time taken and the
+	// time taken by the backend (in microseconds).
+	GofrExtended = MustParse(GofrExtendedFormat)
+
+	// JSON writes each entry as a JSON object.
+	JSON = FormatFunc(func(buf *bytes.Buffer, e *Entry) {
+		b, _ := json.Marshal(e)
+		buf.Write(b)
+	})
+)
+
+// Format strings for the standard formats
+const (
+	CommonFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+	GofrFormat     = `%h %l %u %t "%r" %>s %b "%{url}x" "%{User-Agent}i" %{request_id}x`
+
+	GofrExtendedFormat = GofrFormat + ` %{backend}x %D %{upstream}x`
+)
+
+// Lookup returns the named standard format ("common", "combined", "gofr",
+// "gofr-extended" or "json") or parses the name as a format string if it
+// contains a %.
+func Lookup(name string) (Format, error) {
+	switch name {
+	case "common", "clf":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "gofr":
+		return Gofr, nil
+	case "gofr-extended":
+		return GofrExtended, nil
+	case "json":
+		return JSON, nil
+	}
+	if strings.Contains(name, "%") {
+		return Parse(name)
+	}
+	return nil, fmt.Errorf("unknown access log format %q", name)
+}
+
+// A directive writes one field of an entry.
+type directive func(buf *bytes.Buffer, e *Entry)
+
+// A template is a Format parsed from a format string.
+type template []directive
+
+func (t template) Format(buf *bytes.Buffer, e *Entry) {
+	for _, d := range t {
+		d(buf, e)
+	}
+}
+
+// Parse parses a format string in the style of Apache's mod_log_config.
+// The following directives are supported:
+//   %%            - A literal percent sign
+//   %h            - Remote IP address
+//   %l            - Remote logname (always "-")
+//   %u            - Remote (authenticated) user
+//   %t            - Time the request was received, in [02/Jan/2006:15:04:05 -0700] format
+//   %r            - First line of the request
+//   %s, %>s       - Status code
+//   %b            - Size of the response body ("-" if empty)
+//   %B            - Size of the response body
+//   %I            - Size of the request body
+//   %O            - Size of the response body (same as %B)
+//   %D            - Time taken to serve the request, in microseconds
+//   %T            - Time taken to serve the request, in seconds
+//   %m            - Request method
+//   %U            - URL path requested
+//   %q            - Query string (prepended with ? if it is not empty)
+//   %H            - Request protocol
+//   %v            - Host requested
+//   %{Name}i      - Value of the named request header
+//   %{Name}o      - Value of the named response header
+//
+// The following gofr-specific values are also available:
+//   %{url}x          - Full URL requested
+//   %{scheme}x       - "http" or "https"
+//   %{request_id}x   - Request ID
+//   %{tls}x          - TLS version, if any
+//   %{backend}x      - Name of the backend which served the request
+//   %{backend_host}x - Host chosen to serve the request
+//   %{upstream}x     - Time taken by the backend, in microseconds
+//
+// Values which are unknown or empty are logged as "-".  Quotes,
+// backslashes and control characters in values are escaped.
+func Parse(format string) (Format, error) {
+	var t template
+	literal := func(s string) {
+		if s != "" {
+			t = append(t, func(buf *bytes.Buffer, e *Entry) { buf.WriteString(s) })
+		}
+	}
+
+	for {
+		pct := strings.Index(format, "%")
+		if pct < 0 {
+			literal(format)
+			return t, nil
+		}
+		literal(format[:pct])
+		format = format[pct+1:]
+
+		var arg string
+		if strings.HasPrefix(format, "{") {
+			end := strings.Index(format, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %%{ in access log format")
+			}
+			arg, format = format[1:end], format[end+1:]
+		}
+		format = strings.TrimPrefix(format, ">")
+		if format == "" {
+			return nil, fmt.Errorf("access log format ends with %%")
+		}
+		verb := format[0]
+		format = format[1:]
+
+		d, err := parseDirective(verb, arg)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, d)
+	}
+}
+
+// MustParse is like Parse but panics if the format is invalid.
+func MustParse(format string) Format {
+	f, err := Parse(format)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// value returns a directive which writes the escaped string or "-".
+func value(fn func(e *Entry) string) directive {
+	return func(buf *bytes.Buffer, e *Entry) {
+		escape(buf, fn(e))
+	}
+}
+
+func micros(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Microsecond), 10)
+}
+
+func parseDirective(verb byte, arg string) (directive, error) {
+	if arg != "" {
+		switch verb {
+		case 'i':
+			return value(func(e *Entry) string {
+				if e.req == nil {
+					return ""
+				}
+				return e.req.Header.Get(arg)
+			}), nil
+		case 'o':
+			return value(func(e *Entry) string { return e.resp.Get(arg) }), nil
+		case 'x':
+			fn, ok := extensions[arg]
+			if !ok {
+				return nil, fmt.Errorf("unknown access log value %%{%s}x", arg)
+			}
+			return value(fn), nil
+		}
+		return nil, fmt.Errorf("unknown access log directive %%{%s}%c", arg, verb)
+	}
+
+	switch verb {
+	case '%':
+		return func(buf *bytes.Buffer, e *Entry) { buf.WriteByte('%') }, nil
+	case 't':
+		return func(buf *bytes.Buffer, e *Entry) {
+			buf.WriteString(e.Time.Format("[02/Jan/2006:15:04:05 -0700]"))
+		}, nil
+	case 'b':
+		return value(func(e *Entry) string {
+			if e.BytesOut == 0 {
+				return ""
+			}
+			return strconv.FormatInt(e.BytesOut, 10)
+		}), nil
+	}
+	if fn, ok := directives[verb]; ok {
+		return value(fn), nil
+	}
+	return nil, fmt.Errorf("unknown access log directive %%%c", verb)
+}
+
+var directives = map[byte]func(e *Entry) string{
+	'h': func(e *Entry) string { return e.RemoteAddr },
+	'l': func(e *Entry) string { return "" },
+	'u': func(e *Entry) string { return e.User },
+	'r': func(e *Entry) string { return e.Method + " " + e.URI + " " + e.Proto },
+	's': func(e *Entry) string { return strconv.Itoa(e.Status) },
+	'B': func(e *Entry) string { return strconv.FormatInt(e.BytesOut, 10) },
+	'O': func(e *Entry) string { return strconv.FormatInt(e.BytesOut, 10) },
+	'I': func(e *Entry) string { return strconv.FormatInt(e.BytesIn, 10) },
+	'D': func(e *Entry) string { return micros(e.Duration) },
+	'T': func(e *Entry) string { return strconv.FormatInt(int64(e.Duration/time.Second), 10) },
+	'm': func(e *Entry) string { return e.Method },
+	'U': func(e *Entry) string { return e.Path() },
+	'q': func(e *Entry) string {
+		if i := strings.Index(e.URI, "?"); i >= 0 && i < len(e.URI)-1 {
+			return e.URI[i:]
+		}
+		return ""
+	},
+	'H': func(e *Entry) string { return e.Proto },
+	'v': func(e *Entry) string { return e.Host },
+}
+
+var extensions = map[string]func(e *Entry) string{
+	"url":          func(e *Entry) string { return e.URL() },
+	"scheme":       func(e *Entry) string { return e.Scheme },
+	"request_id":   func(e *Entry) string { return e.RequestID },
+	"tls":          func(e *Entry) string { return e.TLSVersion },
+	"backend":      func(e *Entry) string { return e.Backend },
+	"backend_host": func(e *Entry) string { return e.BackendHost },
+	"upstream": func(e *Entry) string {
+		if e.Backend == "" {
+			return ""
+		}
+		return micros(e.Upstream)
+	},
+}
+
+// escape writes s (or "-" if it is empty) with quotes, backslashes and
+// non-printable characters escaped.
+func escape(buf *bytes.Buffer, s string) {
+	if s == "" {
+		buf.WriteByte('-')
+		return
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(buf, "\\x%02x", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	urlpkg "net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Reader reads entries from an access log written in one of the
+// standard formats ("common", "combined", "gofr", "gofr-extended" or "json").
+// Entries read from a log cannot be used with FromRequest.
+type Reader struct {
+	scan  *bufio.Scanner
+	parse func(line string) (*Entry, error)
+	line  int
+}
+
+// NewReader returns a Reader for a log in the named format.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	parse, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("cannot read access logs in format %q", format)
+	}
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<20)
+	return &Reader{scan: scan, parse: parse}, nil
+}
+
+// Next returns the next entry in the log.  Blank lines are skipped.
+// At the end of the log, it returns io.EOF.
+func (r *Reader) Next() (*Entry, error) {
+	for r.scan.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scan.Text())
+		if line == "" {
+			continue
+		}
+		e, err := r.parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", r.line, err)
+		}
+		return e, nil
+	}
+	if err := r.scan.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Open opens a log file for reading, decompressing it if its name
+// ends in ".gz".  The caller must close the returned ReadCloser.
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return gzipFile{gz, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+var parsers = map[string]func(string) (*Entry, error){
+	"common":        textParser(false),
+	"clf":           textParser(false),
+	"combined":      textParser(false),
+	"gofr":          textParser(true),
+	"gofr-extended": textParser(true),
+	"json":          parseJSON,
+}
+
+// Timed reports whether logs in the named format record the backend and
+// the time taken by each request, without which Stats has no latencies.
+func Timed(format string) bool {
+	return format == "gofr-extended" || format == "json"
+}
+
+func parseJSON(line string) (*Entry, error) {
+	e := new(Entry)
+	if err := json.Unmarshal([]byte(line), e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+const quoted = `"((?:[^"\\]|\\.)*)"`
+
+// textLine matches the Common, Combined, Gofr and GofrExtended formats.
+// Combined and Gofr differ only in the contents of the first optional quoted
+// field; GofrExtended adds three more fields to the end of Gofr.
+var textLine = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] ` + quoted + ` (\d{3}) (\S+)` +
+	`(?: ` + quoted + ` ` + quoted + `)?` +
+	`(?: (\S+))?(?: (\S+) (\S+) (\S+))?$`)
+
+// textParser returns a parser for the text formats.  In the Gofr format,
+// the field in the position of the referer is the full URL.
+func textParser(gofr bool) func(string) (*Entry, error) {
+	return func(line string) (*Entry, error) {
+		return parseText(line, gofr)
+	}
+}
+
+// parseText parses a line in any of the text formats.
+func parseText(line string, gofr bool) (*Entry, error) {
+	m := textLine.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("unrecognized log line")
+	}
+	e := &Entry{
+		RemoteAddr: field(m[1]),
+		User:       field(m[3]),
+	}
+
+	var err error
+	if e.Time, err = time.Parse("02/Jan/2006:15:04:05 -0700", m[4]); err != nil {
+		return nil, err
+	}
+	request := strings.SplitN(unescape(m[5]), " ", 3)
+	if len(request) != 3 {
+		return nil, fmt.Errorf("malformed request line %q", m[5])
+	}
+	e.Method, e.URI, e.Proto = request[0], request[1], request[2]
+	e.Status, _ = strconv.Atoi(m[6])
+	if m[7] != "-" {
+		if e.BytesOut, err = strconv.ParseInt(m[7], 10, 64); err != nil {
+			return nil, fmt.Errorf("malformed size %q", m[7])
+		}
+	}
+
+	if gofr {
+		u, err := urlpkg.Parse(unescape(m[8]))
+		if err == nil && u.Host != "" {
+			e.Scheme, e.Host = u.Scheme, u.Host
+		}
+	} else {
+		e.Referer = field(unescape(m[8]))
+	}
+	e.UserAgent = field(unescape(m[9]))
+
+	e.RequestID = field(m[10])
+	if m[11] != "" {
+		e.Backend = field(m[11])
+		e.Duration = fieldMicros(m[12])
+		e.Upstream = fieldMicros(m[13])
+	}
+	return e, nil
+}
+
+// field returns the value of a field, which is empty if it is "-".
+func field(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func fieldMicros(s string) time.Duration {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Duration(n) * time.Microsecond
+}
+
+// unescape reverses the escaping done when the value was logged.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		if s[i] == 'x' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
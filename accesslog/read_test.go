@@ -0,0 +1,123 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		check  func(e *Entry) bool
+	}{
+		{"common", Common, func(e *Entry) bool { return e.Referer == "" && e.UserAgent == "" }},
+		{"combined", Combined, func(e *Entry) bool { return e.UserAgent == `Mozilla "quoted"` }},
+		{"gofr", Gofr, func(e *Entry) bool {
+			return e.URL() == "https://example.com/path?q=1" && e.RequestID == "abc123" && e.Backend == ""
+		}},
+		{"gofr-extended", GofrExtended, func(e *Entry) bool {
+			return e.URL() == "https://example.com/path?q=1" && e.RequestID == "abc123" &&
+				e.Backend == "blog" && e.Upstream == 1500*time.Microsecond
+		}},
+		{"json", JSON, func(e *Entry) bool { return e.BackendHost == "localhost:8001" }},
+	}
+
+	for _, test := range tests {
+		line := serve(t, test.format)
+		r, err := NewReader(strings.NewReader(line+"\n\n"+line+"\n"), test.name)
+		if err != nil {
+			t.Fatalf("%s: NewReader: %s", test.name, err)
+		}
+		for i := 0; i < 2; i++ {
+			e, err := r.Next()
+			if err != nil {
+				t.Fatalf("%s: Next: %s", test.name, err)
+			}
+			if e.Method != "POST" || e.Path() != "/path" || e.Status != 404 || e.BytesOut != 8 || e.User != "alice" {
+				t.Errorf("%s: read %+v from %q", test.name, e, line)
+			}
+			if !test.check(e) {
+				t.Errorf("%s: read %+v from %q", test.name, e, line)
+			}
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("%s: Next at end = %v, want EOF", test.name, err)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := NewReader(strings.NewReader(""), "%h"); err == nil {
+		t.Errorf("NewReader with a custom format succeeded, want error")
+	}
+	r, _ := NewReader(strings.NewReader("\nnot a log line\n"), "gofr")
+	if _, err := r.Next(); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Next = %v, want error on line 2", err)
+	}
+}
+
+func TestTimed(t *testing.T) {
+	tests := []struct {
+		format string
+		want   bool
+	}{
+		{"common", false},
+		{"combined", false},
+		{"gofr", false},
+		{"gofr-extended", true},
+		{"json", true},
+	}
+	for _, test := range tests {
+		if got := Timed(test.format); got != test.want {
+			t.Errorf("Timed(%q) = %v, want %v", test.format, got, test.want)
+		}
+	}
+}
+
+func TestOpenGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	io.WriteString(gz, "hello\n")
+	gz.Close()
+	f.Close()
+
+	rc, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer rc.Close()
+	if b, _ := ioutil.ReadAll(rc); string(b) != "hello\n" {
+		t.Errorf("read %q, want %q", b, "hello\n")
+	}
+}
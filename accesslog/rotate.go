@@ -0,0 +1,309 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kylelemons.net/go/daemon"
+)
+
+// A File is a log file which rotates itself.  Each call to Write is
+// written to a single file, so a Logger never splits a line across files.
+//
+// When the file is rotated, it is renamed with the time of the rotation
+// appended (e.g. access.log.20130102-150405) and optionally compressed
+// (access.log.20130102-150405.gz) in the background.
+//
+// The settings must not be changed once the File is in use.
+type File struct {
+	Path string
+	Mode os.FileMode // permissions for new files (default 0600)
+
+	// MaxSize, if nonzero, is the size in bytes above which the file is rotated.
+	MaxSize int64
+
+	// Interval, if nonzero, causes the file to be rotated when a write occurs
+	// in a different interval (e.g. 24*time.Hour for daily rotation at
+	// midnight UTC) than the previous one.
+	Interval time.Duration
+
+	// Compress causes rotated files to be compressed with gzip.
+	Compress bool
+
+	// MaxBackups, if nonzero, is the number of rotated files to keep.
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	last time.Time      // time of the last write
+	wg   sync.WaitGroup // background compression
+
+	now func() time.Time // for testing
+}
+
+// OpenFile opens (or creates) the file at path for appending.  The returned
+// File does not rotate until its settings are changed, which must happen
+// before it is used.
+func OpenFile(path string) (*File, error) {
+	f := &File{Path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+func (f *File) interval(t time.Time) time.Time {
+	if f.Interval <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(f.Interval)
+}
+
+// open opens the file.  f.mu must be held.
+func (f *File) open() error {
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size, f.last = file, info.Size(), info.ModTime()
+	return nil
+}
+
+// Reopen closes and reopens the file, which is useful if it has been
+// moved aside by an external program (e.g. logrotate).
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f != nil {
+		f.f.Close()
+		f.f = nil
+	}
+	return f.open()
+}
+
+// Write writes b to the file, rotating it first if necessary.
+func (f *File) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	now := f.clock()
+	switch {
+	case f.size > 0 && f.MaxSize > 0 && f.size+int64(len(b)) > f.MaxSize,
+		f.size > 0 && !f.interval(now).Equal(f.interval(f.last)):
+		if err := f.rotate(); err != nil {
+			daemon.Error.Printf("rotating %s: %s", f.Path, err)
+		}
+		if f.f == nil {
+			if err := f.open(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	f.last = now
+
+	n, err := f.f.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately.
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	return f.rotate()
+}
+
+// rotate renames the current file and opens a new one.  f.mu must be held.
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+
+	stamp := f.clock().UTC().Format("20060102-150405")
+	name := f.Path + "." + stamp
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%s.%d", f.Path, stamp, i)
+	}
+	if err := os.Rename(f.Path, name); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if !f.Compress {
+		f.prune()
+		return nil
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		if err := compress(name); err != nil {
+			daemon.Error.Printf("compressing %s: %s", name, err)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.prune()
+	}()
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compress replaces the named file with a gzipped version.
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// backupSuffix matches the suffixes rotate gives to backups: the time of
+// the rotation, an optional sequence number and an optional ".gz".
+var backupSuffix = regexp.MustCompile(`^\.(\d{8}-\d{6})(?:\.(\d+))?(\.gz)?$`)
+
+// Backups returns the rotated files, oldest first.  Other files whose names
+// begin with Path (such as those left by logrotate) are not included.
+func (f *File) Backups() ([]string, error) {
+	matches, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, m := range matches {
+		if !backupSuffix.MatchString(m[len(f.Path):]) {
+			continue
+		}
+		// Skip partially compressed files
+		if !strings.HasSuffix(m, ".gz") && exists(m+".gz") {
+			continue
+		}
+		backups = append(backups, m)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		si, ni := f.backupOrder(backups[i])
+		sj, nj := f.backupOrder(backups[j])
+		if si != sj {
+			return si < sj
+		}
+		return ni < nj
+	})
+	return backups, nil
+}
+
+// backupOrder returns the timestamp and sequence number of a backup,
+// so that access.log.20130102-150405 sorts before access.log.20130102-150405.1.
+func (f *File) backupOrder(name string) (stamp string, seq int) {
+	m := backupSuffix.FindStringSubmatch(name[len(f.Path):])
+	seq, _ = strconv.Atoi(m[2])
+	return m[1], seq
+}
+
+// prune removes the oldest backups beyond MaxBackups.  f.mu must be held,
+// so that concurrent rotations do not remove the same backups.
+func (f *File) prune() {
+	if f.MaxBackups <= 0 {
+		return
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		daemon.Error.Printf("listing backups of %s: %s", f.Path, err)
+		return
+	}
+	for len(backups) > f.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			daemon.Error.Printf("removing old log: %s", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// Close waits for any background compression and closes the file.
+func (f *File) Close() error {
+	f.wg.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
@@ -0,0 +1,235 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempLog(t *testing.T) (dir, path string) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	return dir, filepath.Join(dir, "access.log")
+}
+
+// readLines returns the lines in the (possibly gzipped) file.
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("gzip %s: %s", path, err)
+		}
+		r = gz
+	}
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines
+}
+
+func TestRotateSize(t *testing.T) {
+	dir, path := tempLog(t)
+	defer os.RemoveAll(dir)
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	f.MaxSize = 100
+	f.Compress = true
+	f.MaxBackups = 3
+
+	// Write from many goroutines at once
+	const lines = 50
+	var wg sync.WaitGroup
+	for i := 0; i < lines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fmt.Fprintf(f, "line %02d %s\n", i, strings.Repeat("x", 20))
+		}(i)
+	}
+	wg.Wait()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Backups: %s", err)
+	}
+	if got, want := len(backups), 3; got != want {
+		t.Errorf("%d backups, want %d: %q", got, want, backups)
+	}
+	for _, b := range append(backups, path) {
+		if b != path && !strings.HasSuffix(b, ".gz") {
+			t.Errorf("backup %q is not compressed", b)
+		}
+		for _, line := range readLines(t, b) {
+			if len(line) != 28 || !strings.HasPrefix(line, "line ") {
+				t.Errorf("%s: corrupt line %q", b, line)
+			}
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 100 {
+		t.Errorf("current log: %v, %v; want at most 100 bytes", info, err)
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	dir, path := tempLog(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2013, 1, 2, 23, 59, 0, 0, time.UTC)
+	f := &File{
+		Path:     path,
+		Interval: 24 * time.Hour,
+		now:      func() time.Time { return now },
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "monday")
+	now = now.Add(30 * time.Second)
+	fmt.Fprintln(f, "still monday")
+	now = now.Add(time.Minute)
+	fmt.Fprintln(f, "tuesday")
+
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Backups: %s", err)
+	}
+	if got, want := backups, []string{path + ".20130103-000030"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("backups = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(readLines(t, backups[0]), ","), "monday,still monday"; got != want {
+		t.Errorf("rotated lines = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(readLines(t, path), ","), "tuesday"; got != want {
+		t.Errorf("current lines = %q, want %q", got, want)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir, path := tempLog(t)
+	defer os.RemoveAll(dir)
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rename: %s", err)
+	}
+	fmt.Fprintln(f, "moved")
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("Reopen: %s", err)
+	}
+	fmt.Fprintln(f, "after")
+
+	if got, want := strings.Join(readLines(t, path+".1"), ","), "before,moved"; got != want {
+		t.Errorf("old lines = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(readLines(t, path), ","), "after"; got != want {
+		t.Errorf("new lines = %q, want %q", got, want)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	dir, path := tempLog(t)
+	defer os.RemoveAll(dir)
+
+	names := []string{
+		".20130102-150405.2.gz",
+		".20130102-150405.gz",
+		".20130102-150405.10",
+		".20130101-000000.gz",
+		".20130102-150405.1.gz",
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(path+name, nil, 0600); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+
+	f := &File{Path: path}
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Backups: %s", err)
+	}
+	for i := range backups {
+		backups[i] = strings.TrimPrefix(backups[i], path)
+	}
+	want := []string{
+		".20130101-000000.gz",
+		".20130102-150405.gz",
+		".20130102-150405.1.gz",
+		".20130102-150405.2.gz",
+		".20130102-150405.10",
+	}
+	if got := strings.Join(backups, " "); got != strings.Join(want, " ") {
+		t.Errorf("backups = %s, want %s", got, strings.Join(want, " "))
+	}
+}
+
+func TestPruneForeign(t *testing.T) {
+	dir, path := tempLog(t)
+	defer os.RemoveAll(dir)
+
+	foreign := []string{".1.gz", ".bak", ".20130101", ".20130101-000000.bak"}
+	for _, name := range append(foreign, ".20130101-000000.gz", ".20130102-000000.gz") {
+		if err := ioutil.WriteFile(path+name, nil, 0600); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+
+	f := &File{Path: path, MaxBackups: 1}
+	f.prune()
+
+	for _, name := range foreign {
+		if !exists(path + name) {
+			t.Errorf("prune removed %s, which it did not create", name)
+		}
+	}
+	if exists(path + ".20130101-000000.gz") {
+		t.Errorf("prune kept the oldest backup")
+	}
+	if !exists(path + ".20130102-000000.gz") {
+		t.Errorf("prune removed the newest backup")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Stats summarizes the entries in an access log.
+type Stats struct {
+	First, Last time.Time // times of the earliest and latest entries
+	Requests    int
+	Bytes       int64 // response bytes served
+
+	Status     map[int]int    // requests by status code
+	Paths      map[string]int // requests by path
+	NotFound   map[string]int // 404s by path
+	Sources    map[string]int // 404s by referer (or client, if there is no referer)
+	UserAgents map[string]int // requests by user agent
+	Backends   map[string]*BackendStats
+}
+
+// BackendStats summarizes the requests served by a single backend.
+type BackendStats struct {
+	Requests  int
+	Errors    int // 5xx responses
+	Bytes     int64
+	latencies []time.Duration
+	sorted    bool
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{
+		Status:     make(map[int]int),
+		Paths:      make(map[string]int),
+		NotFound:   make(map[string]int),
+		Sources:    make(map[string]int),
+		UserAgents: make(map[string]int),
+		Backends:   make(map[string]*BackendStats),
+	}
+}
+
+// Add adds an entry to the statistics.
+func (s *Stats) Add(e *Entry) {
+	if s.Requests == 0 || e.Time.Before(s.First) {
+		s.First = e.Time
+	}
+	if s.Requests == 0 || e.Time.After(s.Last) {
+		s.Last = e.Time
+	}
+	s.Requests++
+	s.Bytes += e.BytesOut
+	s.Status[e.Status]++
+	s.Paths[e.Path()]++
+	if e.UserAgent != "" {
+		s.UserAgents[e.UserAgent]++
+	}
+	if e.Status == http.StatusNotFound {
+		s.NotFound[e.Path()]++
+		source := e.Referer
+		if source == "" {
+			source = e.RemoteAddr
+		}
+		s.Sources[source]++
+	}
+
+	if e.Backend == "" {
+		return
+	}
+	b, ok := s.Backends[e.Backend]
+	if !ok {
+		b = new(BackendStats)
+		s.Backends[e.Backend] = b
+	}
+	b.Requests++
+	b.Bytes += e.BytesOut
+	if e.Status >= 500 {
+		b.Errors++
+	}
+	latency := e.Upstream
+	if latency == 0 {
+		latency = e.Duration
+	}
+	b.latencies = append(b.latencies, latency)
+	b.sorted = false
+}
+
+// Percentile returns the latency below which the given fraction
+// (between 0 and 1) of the backend's requests were served.
+func (b *BackendStats) Percentile(p float64) time.Duration {
+	if len(b.latencies) == 0 {
+		return 0
+	}
+	if !b.sorted {
+		sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+		b.sorted = true
+	}
+	i := int(p*float64(len(b.latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(b.latencies) {
+		i = len(b.latencies) - 1
+	}
+	return b.latencies[i]
+}
+
+// A Count is a key and the number of times it occurred.
+type Count struct {
+	Key   string
+	Count int
+}
+
+// Top returns the n most frequent keys in counts (all of them if n <= 0),
+// most frequent first.
+func Top(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for k, c := range counts {
+		top = append(top, Count{k, c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Report writes a human-readable summary, listing the top n of each
+// kind of key.
+func (s *Stats) Report(w io.Writer, n int) {
+	if s.Requests == 0 {
+		fmt.Fprintf(w, "No requests.\n")
+		return
+	}
+	fmt.Fprintf(w, "%d requests from %s to %s\n", s.Requests,
+		s.First.Format(time.RFC3339), s.Last.Format(time.RFC3339))
+	fmt.Fprintf(w, "%s served\n", formatBytes(s.Bytes))
+
+	fmt.Fprintf(w, "\nStatus codes:\n")
+	codes := make([]int, 0, len(s.Status))
+	for code := range s.Status {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		count := s.Status[code]
+		fmt.Fprintf(w, "  %3d %-24s %8d %5.1f%%\n", code, http.StatusText(code), count,
+			100*float64(count)/float64(s.Requests))
+	}
+
+	section := func(title string, counts map[string]int) {
+		if len(counts) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s:\n", title)
+		for _, c := range Top(counts, n) {
+			fmt.Fprintf(w, "  %8d  %s\n", c.Count, c.Key)
+		}
+	}
+	section("Top paths", s.Paths)
+	section("Top 404s", s.NotFound)
+	section("Top 404 sources", s.Sources)
+
+	if len(s.Backends) > 0 {
+		names := make([]string, 0, len(s.Backends))
+		for name := range s.Backends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(w, "\nBackends:\n")
+		fmt.Fprintf(w, "  %-16s %8s %6s %10s %10s %10s %10s %10s\n",
+			"name", "requests", "5xx", "bytes", "p50", "p90", "p99", "max")
+		for _, name := range names {
+			b := s.Backends[name]
+			fmt.Fprintf(w, "  %-16s %8d %6d %10s %10s %10s %10s %10s\n",
+				name, b.Requests, b.Errors, formatBytes(b.Bytes),
+				b.Percentile(0.5), b.Percentile(0.9), b.Percentile(0.99), b.Percentile(1))
+		}
+	}
+
+	section("Top user agents", s.UserAgents)
+}
+
+// formatBytes returns a human-readable size.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
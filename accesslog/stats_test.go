@@ -0,0 +1,81 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	start := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStats()
+	for i := 0; i < 10; i++ {
+		s.Add(&Entry{
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			URI:      "/blog/?page=1",
+			Status:   200,
+			BytesOut: 100,
+			Backend:  "blog",
+			Upstream: time.Duration(i+1) * time.Millisecond,
+		})
+	}
+	s.Add(&Entry{Time: start, URI: "/missing", Status: 404, RemoteAddr: "1.2.3.4", UserAgent: "bot"})
+	s.Add(&Entry{Time: start, URI: "/missing", Status: 404, Referer: "http://example.com/", UserAgent: "bot"})
+
+	if s.Requests != 12 || s.Bytes != 1000 {
+		t.Errorf("requests, bytes = %d, %d, want 12, 1000", s.Requests, s.Bytes)
+	}
+	if got, want := s.Last.Sub(s.First), 9*time.Minute; got != want {
+		t.Errorf("window = %s, want %s", got, want)
+	}
+	if s.Status[200] != 10 || s.Status[404] != 2 {
+		t.Errorf("status = %v", s.Status)
+	}
+	if got := Top(s.Paths, 1); len(got) != 1 || got[0] != (Count{"/blog/", 10}) {
+		t.Errorf("top path = %v, want /blog/", got)
+	}
+	if s.NotFound["/missing"] != 2 || s.Sources["1.2.3.4"] != 1 || s.Sources["http://example.com/"] != 1 {
+		t.Errorf("404s = %v from %v", s.NotFound, s.Sources)
+	}
+
+	b := s.Backends["blog"]
+	if b == nil || b.Requests != 10 {
+		t.Fatalf("backends = %v", s.Backends)
+	}
+	for _, test := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 5 * time.Millisecond},
+		{0.9, 9 * time.Millisecond},
+		{0.99, 10 * time.Millisecond},
+		{1, 10 * time.Millisecond},
+	} {
+		if got := b.Percentile(test.p); got != test.want {
+			t.Errorf("Percentile(%v) = %s, want %s", test.p, got, test.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	s.Report(&buf, 5)
+	for _, want := range []string{"12 requests", "404 Not Found", "Top 404s:", "blog", "bot"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"kylelemons.net/go/daemon"
+)
+
+// An ACL restricts access to a handler based on the IP address of the client.
+//
+// Addresses are checked against Deny first and then against Allow.  An address
+// which appears in neither list is permitted only if DefaultAllow is set.
+// Both IPv4 and IPv6 networks may be used (see MustCIDR).
+//
+// The client address is normally taken from the RemoteAddr of the request.
+// If the connection comes from one of the TrustedProxies, the address is
+// instead taken from the X-Forwarded-For header (see ClientIP).
+//
+// An ACL must not be modified once it begins to serve traffic.
+type ACL struct {
+	Name string // shown in log messages (e.g. "debug")
+
+	Allow        []*net.IPNet // networks which are permitted
+	Deny         []*net.IPNet // networks which are blocked, even if also in Allow
+	DefaultAllow bool         // permit addresses which are in neither list
+
+	// Status is the response code for blocked requests.  It defaults to
+	// 403 Forbidden; use 404 Not Found to hide the existence of the resource.
+	Status int
+
+	TrustedProxies []*net.IPNet // proxies whose X-Forwarded-For is honored
+}
+
+// Permit reports whether the client which made the request is allowed by the
+// ACL and the reason for the decision, which is suitable for logging.
+func (a *ACL) Permit(r *http.Request) (bool, string) {
+	ip, err := ClientIP(r, a.TrustedProxies)
+	if err != nil {
+		return false, err.Error()
+	}
+	if n, ok := containsIP(a.Deny, ip); ok {
+		return false, fmt.Sprintf("%s is in denied network %s", ip, n)
+	}
+	if n, ok := containsIP(a.Allow, ip); ok {
+		return true, fmt.Sprintf("%s is in allowed network %s", ip, n)
+	}
+	if a.DefaultAllow {
+		return true, fmt.Sprintf("%s is allowed by default", ip)
+	}
+	return false, fmt.Sprintf("%s is not in an allowed network", ip)
+}
+
+// ServeHandler serves the request with h if it is permitted by the ACL
+// and serves the configured Status otherwise.
+func (a *ACL) ServeHandler(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	if a.check(w, r) {
+		h.ServeHTTP(w, r)
+	}
+}
+
+// check logs the access decision for the request and reports whether it
+// should be served.  If it should not, the blocked response is written to w.
+func (a *ACL) check(w http.ResponseWriter, r *http.Request) bool {
+	ok, why := a.Permit(r)
+	if !ok {
+		daemon.Warning.Printf("%s[%s] BLOCKED %s access to %s: %s", LogPrefix(r), r.RemoteAddr, a.Name, r.URL.Path, why)
+		code := a.Status
+		if code == 0 {
+			code = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(code), code)
+		return false
+	}
+	daemon.Verbose.Printf("%s[%s] Allowed %s access to %s: %s", LogPrefix(r), r.RemoteAddr, a.Name, r.URL.Path, why)
+	return true
+}
+
+// Wrap returns a handler which serves h only to clients permitted by the ACL.
+func (a *ACL) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.ServeHandler(w, r, h)
+	})
+}
+
+// ClientIP determines the IP address of the client which made the request.
+//
+// If the immediate peer (from RemoteAddr) is in one of the trusted networks,
+// the X-Forwarded-For header is examined from right to left and the first
+// address which is not itself trusted is returned.  If every address in the
+// chain is trusted, the leftmost one is returned.
+func ClientIP(r *http.Request, trusted []*net.IPNet) (net.IP, error) {
+	rawIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split addr: %s", err)
+	}
+	ip := net.ParseIP(rawIP)
+	if ip == nil {
+		return nil, fmt.Errorf("%q could not be parsed", rawIP)
+	}
+	if _, ok := containsIP(trusted, ip); !ok {
+		return ip, nil
+	}
+
+	chain := forwardedFor(r.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := net.ParseIP(chain[i])
+		if hop == nil {
+			// Anything to the left of garbage can't be trusted
+			break
+		}
+		ip = hop
+		if _, ok := containsIP(trusted, ip); !ok {
+			break
+		}
+	}
+	return ip, nil
+}
+
+// forwardedFor returns the addresses listed in the X-Forwarded-For header(s).
+func forwardedFor(h http.Header) []string {
+	var chain []string
+	for _, line := range h["X-Forwarded-For"] {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// containsIP returns the first network in nets which contains ip.
+func containsIP(nets []*net.IPNet, ip net.IP) (*net.IPNet, bool) {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return n, true
+		}
+	}
+	return nil, false
+}
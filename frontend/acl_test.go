@@ -0,0 +1,118 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		desc string
+		acl  *ACL
+		ip   string
+		xff  string
+		code int
+	}{
+		{
+			desc: "allowed",
+			acl:  &ACL{Allow: []*net.IPNet{MustCIDR("10.0.0.0/8")}},
+			ip:   "10.1.2.3",
+			code: 200,
+		},
+		{
+			desc: "not allowed",
+			acl:  &ACL{Allow: []*net.IPNet{MustCIDR("10.0.0.0/8")}},
+			ip:   "11.1.2.3",
+			code: 403,
+		},
+		{
+			desc: "not allowed (hidden)",
+			acl:  &ACL{Allow: []*net.IPNet{MustCIDR("10.0.0.0/8")}, Status: 404},
+			ip:   "11.1.2.3",
+			code: 404,
+		},
+		{
+			desc: "denied overrides allowed",
+			acl: &ACL{
+				Allow: []*net.IPNet{MustCIDR("10.0.0.0/8")},
+				Deny:  []*net.IPNet{MustCIDR("10.1.0.0/16")},
+			},
+			ip:   "10.1.2.3",
+			code: 403,
+		},
+		{
+			desc: "default allow",
+			acl:  &ACL{Deny: []*net.IPNet{MustCIDR("10.1.0.0/16")}, DefaultAllow: true},
+			ip:   "11.1.2.3",
+			code: 200,
+		},
+		{
+			desc: "ipv6 denied",
+			acl:  &ACL{Deny: []*net.IPNet{MustCIDR("2001:db8::/32")}, DefaultAllow: true},
+			ip:   "2001:db8::1",
+			code: 403,
+		},
+		{
+			desc: "untrusted forwarded for",
+			acl:  &ACL{Allow: []*net.IPNet{MustCIDR("10.0.0.0/8")}},
+			ip:   "11.1.2.3",
+			xff:  "10.1.2.3",
+			code: 403,
+		},
+		{
+			desc: "trusted forwarded for",
+			acl: &ACL{
+				Allow:          []*net.IPNet{MustCIDR("10.0.0.0/8")},
+				TrustedProxies: []*net.IPNet{MustCIDR("192.168.0.0/16")},
+			},
+			ip:   "192.168.1.1",
+			xff:  "11.1.2.3, 10.1.2.3",
+			code: 200,
+		},
+		{
+			desc: "trusted forwarded for chain",
+			acl: &ACL{
+				Allow:          []*net.IPNet{MustCIDR("10.0.0.0/8")},
+				TrustedProxies: []*net.IPNet{MustCIDR("192.168.0.0/16")},
+			},
+			ip:   "192.168.1.1",
+			xff:  "10.1.2.3, 11.1.2.3, 192.168.7.7",
+			code: 403,
+		},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/foo", nil)
+		if err != nil {
+			t.Fatalf("NewRequest(%q, %q, %#v): %s", "GET", "/foo", nil, err)
+		}
+		req.RemoteAddr = net.JoinHostPort(test.ip, "1224")
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		rec := httptest.NewRecorder()
+		test.acl.Wrap(ok).ServeHTTP(rec, req)
+		if got, want := rec.Code, test.code; got != want {
+			t.Errorf("%s: code = %d %s, want %d %s", test.desc,
+				got, http.StatusText(got), want, http.StatusText(want))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"kylelemons.net/go/daemon"
+	"kylelemons.net/go/gofr/accesslog"
+)
+
+// ErrNoCredentials is returned by Auth.Authenticate if the request
+// does not contain any credentials.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// An Auth requires clients to authenticate before a handler is served.
+//
+// Clients may use HTTP Basic authentication with a username and password
+// from Users, or they may provide one of the Tokens as a bearer token.
+//
+// Once a request is authenticated, the username is stored in its context
+// (see User) and in its access log entry, and the Authorization header
+// is removed so that the credentials are not forwarded to a backend.
+// See Endpoint.UserHeader for passing the username to a backend.
+//
+// An Auth must not be modified once it begins to serve traffic.
+type Auth struct {
+	Realm  string            // realm sent to clients in WWW-Authenticate
+	Users  map[string][]byte // bcrypt password hashes by username (see LoadHtpasswd)
+	Tokens map[string]string // usernames by bearer token
+}
+
+// Authenticate checks the credentials in the request and returns
+// the name of the authenticated user.
+func (a *Auth) Authenticate(r *http.Request) (string, error) {
+	authz := r.Header.Get("Authorization")
+	if authz == "" {
+		return "", ErrNoCredentials
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, ok := a.Users[user]
+		if !ok {
+			return "", fmt.Errorf("unknown user %q", user)
+		}
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(pass)); err != nil {
+			return "", fmt.Errorf("user %q: %s", user, err)
+		}
+		return user, nil
+	}
+
+	const bearer = "Bearer "
+	if len(authz) > len(bearer) && strings.EqualFold(authz[:len(bearer)], bearer) {
+		token := []byte(strings.TrimSpace(authz[len(bearer):]))
+		for t, user := range a.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+				return user, nil
+			}
+		}
+		return "", fmt.Errorf("unknown bearer token")
+	}
+
+	return "", fmt.Errorf("unsupported authorization scheme")
+}
+
+type userKey struct{}
+
+// User returns the name of the user authenticated by an Auth, or "" if the
+// request has not been authenticated.  Unlike r.URL.User, which a client
+// can set by sending an absolute URL, it cannot come from the client.
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// ServeHandler serves the request with h if it is authenticated
+// and serves 401 Unauthorized otherwise.
+func (a *Auth) ServeHandler(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	if r, ok := a.check(w, r); ok {
+		h.ServeHTTP(w, r)
+	}
+}
+
+// check authenticates the request and reports whether it should be served,
+// returning the request with the user added to its context.  If it should
+// not be served, a challenge is written to w.
+func (a *Auth) check(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	user, err := a.Authenticate(r)
+	if err != nil {
+		if err != ErrNoCredentials {
+			daemon.Warning.Printf("%s[%s] FAILED authentication for %s: %s", LogPrefix(r), r.RemoteAddr, r.URL.Path, err)
+		}
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", a.Realm))
+		if len(a.Tokens) > 0 {
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", a.Realm))
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return r, false
+	}
+	daemon.Verbose.Printf("%s[%s] Authenticated %q for %s", LogPrefix(r), r.RemoteAddr, user, r.URL.Path)
+
+	accesslog.FromRequest(r).SetUser(user)
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+	r.Header.Del("Authorization")
+	return r, true
+}
+
+// Wrap returns a handler which serves h only to authenticated clients.
+func (a *Auth) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.ServeHandler(w, r, h)
+	})
+}
+
+// LoadHtpasswd loads bcrypt password hashes from an htpasswd-style file
+// for use as Auth.Users.  Each line of the file has the following form:
+//   <username>:<bcrypt hash>
+//
+// Blank lines and lines beginning with # are ignored.  Hashes of other types
+// (such as MD5 or SHA1) are not supported; use "htpasswd -B" to create them.
+func LoadHtpasswd(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	lines := bufio.NewScanner(f)
+	for lineno := 1; lines.Scan(); lineno++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("%s:%d: missing ':'", file, lineno)
+		}
+		user, hash := line[:colon], line[colon+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: user %q: unsupported hash: %s", file, lineno, user, err)
+		}
+		if _, exist := users[user]; exist {
+			return nil, fmt.Errorf("%s:%d: duplicate user %q", file, lineno, user)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	urlpkg "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"kylelemons.net/go/gofr/accesslog"
+)
+
+func TestLoadHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "authtest-")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		desc  string
+		file  string
+		users []string
+		err   string
+	}{
+		{
+			desc:  "basic",
+			file:  fmt.Sprintf("# comment\n\nalice:%s\nbob:%s\n", hash, hash),
+			users: []string{"alice", "bob"},
+		},
+		{
+			desc: "md5",
+			file: "alice:$apr1$Vv8kWnSf$4WrFXCn.n/Ad6S1LHn.Oe0\n",
+			err:  "unsupported hash",
+		},
+		{
+			desc: "no colon",
+			file: "alice\n",
+			err:  "missing ':'",
+		},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(dir, "htpasswd")
+		if err := ioutil.WriteFile(file, []byte(test.file), 0600); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+		users, err := LoadHtpasswd(file)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: LoadHtpasswd: %v, want error containing %q", test.desc, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: LoadHtpasswd: %s", test.desc, err)
+			continue
+		}
+		if got, want := len(users), len(test.users); got != want {
+			t.Errorf("%s: loaded %d users, want %d", test.desc, got, want)
+		}
+		for _, user := range test.users {
+			if _, ok := users[user]; !ok {
+				t.Errorf("%s: missing user %q", test.desc, user)
+			}
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+	auth := &Auth{
+		Realm:  "test",
+		Users:  map[string][]byte{"alice": hash},
+		Tokens: map[string]string{"s3cr3t": "robot"},
+	}
+
+	tests := []struct {
+		desc  string
+		authz func(*http.Request)
+		code  int
+		user  string
+	}{
+		{
+			desc:  "anonymous",
+			authz: func(*http.Request) {},
+			code:  401,
+		},
+		{
+			desc:  "basic",
+			authz: func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") },
+			code:  200,
+			user:  "alice",
+		},
+		{
+			desc:  "bad password",
+			authz: func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") },
+			code:  401,
+		},
+		{
+			desc:  "unknown user",
+			authz: func(r *http.Request) { r.SetBasicAuth("mallory", "hunter2") },
+			code:  401,
+		},
+		{
+			desc:  "bearer",
+			authz: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") },
+			code:  200,
+			user:  "robot",
+		},
+		{
+			desc:  "bad bearer",
+			authz: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3") },
+			code:  401,
+		},
+	}
+
+	for _, test := range tests {
+		var user, authz string
+		h := auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = User(r)
+			authz = r.Header.Get("Authorization")
+		}))
+
+		req, err := http.NewRequest("GET", "/private", nil)
+		if err != nil {
+			t.Fatalf("NewRequest(%q, %q, %#v): %s", "GET", "/private", nil, err)
+		}
+		req.RemoteAddr = "1.2.3.4:5678"
+		test.authz(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got, want := rec.Code, test.code; got != want {
+			t.Errorf("%s: code = %d %s, want %d %s", test.desc,
+				got, http.StatusText(got), want, http.StatusText(want))
+		}
+		if got, want := user, test.user; got != want {
+			t.Errorf("%s: user = %q, want %q", test.desc, got, want)
+		}
+		if authz != "" {
+			t.Errorf("%s: Authorization header %q was not removed", test.desc, authz)
+		}
+		if rec.Code == 401 && rec.HeaderMap.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: missing WWW-Authenticate challenge", test.desc)
+		}
+	}
+}
+
+func TestUserHeader(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+	auth := &Auth{
+		Realm: "test",
+		Users: map[string][]byte{"alice": hash},
+	}
+
+	var sent http.Header
+	b := &Endpoint{
+		Name:       "test",
+		UserHeader: "X-User",
+		RoundTripper: FuncTripper(func(req *http.Request) (*http.Response, error) {
+			sent = req.Header
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("body")),
+			}, nil
+		}),
+		hosts: []*urlpkg.URL{{Scheme: "fake", Host: "hostname"}},
+	}
+
+	tests := []struct {
+		desc  string
+		auth  *Auth
+		wrap  bool // use auth as middleware instead of Endpoint.Auth
+		basic bool
+		want  string
+	}{
+		{desc: "no auth", want: ""},
+		{desc: "endpoint auth", auth: auth, basic: true, want: "alice"},
+		{desc: "middleware auth", auth: auth, wrap: true, basic: true, want: "alice"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		sent = nil
+		b.Auth = nil
+		var h http.Handler = b
+		if test.wrap {
+			h = test.auth.Wrap(h)
+		} else {
+			b.Auth = test.auth
+		}
+		h = accesslog.New(&buf, accesslog.MustParse("%u")).Wrap(h)
+
+		// The client tries to claim a user with an absolute-form request
+		req, err := http.NewRequest("GET", "http://admin@example.com/path", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		req.RemoteAddr = "1.2.3.4:5678"
+		req.Header.Set("X-User", "admin")
+		if test.basic {
+			req.SetBasicAuth("alice", "hunter2")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if sent == nil {
+			t.Errorf("%s: request was not sent to the backend", test.desc)
+			continue
+		}
+		if got, want := sent.Get("X-User"), test.want; got != want {
+			t.Errorf("%s: X-User = %q, want %q", test.desc, got, want)
+		}
+		want := test.want
+		if want == "" {
+			want = "-"
+		}
+		if got := strings.TrimSpace(buf.String()); got != want {
+			t.Errorf("%s: logged user %q, want %q", test.desc, got, want)
+		}
+	}
+}
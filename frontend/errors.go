@@ -0,0 +1,310 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"kylelemons.net/go/daemon"
+)
+
+// ErrorData is provided to error page templates.
+type ErrorData struct {
+	Status     int    `json:"status"`
+	StatusText string `json:"status_text"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id,omitempty"`
+	Method     string `json:"method"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+}
+
+// DefaultErrorHTML is used for HTML error pages when there is no
+// more specific template.
+var DefaultErrorHTML = htmltemplate.Must(htmltemplate.New("error.html").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Status}} {{.StatusText}}</title></head>
+<body><h1>{{.Status}} {{.StatusText}}</h1>
+<p>{{.Message}}</p>
+{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body></html>
+`))
+
+// ErrorPages renders error responses as HTML or JSON (depending on the
+// Accept header of the request).
+//
+// Templates are chosen by name, from most to least specific.  For example,
+// an HTML page for 503 Service Unavailable would use the first of the
+// following which is defined: "503.html", "5xx.html", "error.html".  JSON
+// templates are named similarly (e.g. "503.json").  If no JSON template is
+// found, the ErrorData is encoded directly.
+//
+// ErrorPages can be attached to a Frontend or to individual domains or
+// directories of a trie.ServeMux with Wrap; handlers beneath it can
+// then render error pages with Error.  Handlers which do not use Error
+// (such as the trie's default 404 handler) only get error pages when
+// Intercept is set.
+type ErrorPages struct {
+	HTML *htmltemplate.Template // if nil, DefaultErrorHTML is used
+	JSON *texttemplate.Template // the "json" function encodes its argument
+
+	// If Intercept is set, error responses (400 and above) from the wrapped
+	// handler (including backends) are replaced with error pages.
+	Intercept bool
+}
+
+// ErrorFuncs are the functions available to JSON error templates.
+var ErrorFuncs = texttemplate.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// LoadErrorPages loads error page templates from the files in dir which
+// are named like the templates (e.g. "404.html", "5xx.json", "error.html").
+func LoadErrorPages(dir string) (*ErrorPages, error) {
+	p := new(ErrorPages)
+	for _, ext := range []string{".html", ".json"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			name := filepath.Base(file)
+			if ext == ".html" {
+				if p.HTML == nil {
+					p.HTML = htmltemplate.New(name)
+				}
+				_, err = p.HTML.New(name).Parse(string(data))
+			} else {
+				if p.JSON == nil {
+					p.JSON = texttemplate.New(name).Funcs(ErrorFuncs)
+				}
+				_, err = p.JSON.New(name).Parse(string(data))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// templateNames returns the names of the templates for the status code,
+// most specific first.
+func templateNames(code int, ext string) []string {
+	return []string{
+		fmt.Sprintf("%d%s", code, ext),
+		fmt.Sprintf("%dxx%s", code/100, ext),
+		"error" + ext,
+	}
+}
+
+// wantsJSON returns true if the client prefers JSON to HTML.
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		switch {
+		case mime == "text/html", mime == "application/xhtml+xml":
+			return false
+		case mime == "application/json", strings.HasSuffix(mime, "+json"):
+			return true
+		}
+	}
+	return false
+}
+
+// Render writes an error page for the given status code and message
+// (which defaults to the status text) to w.  Responses to HEAD requests
+// have the headers of the page but no body.
+func (p *ErrorPages) Render(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	data := &ErrorData{
+		Status:     code,
+		StatusText: http.StatusText(code),
+		Message:    msg,
+		RequestID:  RequestID(r),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+	}
+	if data.Message == "" {
+		data.Message = data.StatusText
+	}
+
+	var body bytes.Buffer
+	var err error
+	ctype := "text/html; charset=utf-8"
+	if wantsJSON(r) {
+		ctype = "application/json"
+		err = p.renderJSON(&body, code, data)
+	} else {
+		html := p.HTML
+		if html == nil {
+			html = DefaultErrorHTML
+		}
+		err = execute(&body, html, templateNames(code, ".html"), data, DefaultErrorHTML)
+	}
+	if err != nil {
+		daemon.Error.Printf("%srendering %d error page: %s", LogPrefix(r), code, err)
+		http.Error(w, data.Message, code)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", ctype)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if r.Method != "HEAD" {
+		w.Write(body.Bytes())
+	}
+}
+
+func (p *ErrorPages) renderJSON(body *bytes.Buffer, code int, data *ErrorData) error {
+	if p.JSON != nil {
+		for _, name := range templateNames(code, ".json") {
+			if t := p.JSON.Lookup(name); t != nil {
+				return t.Execute(body, data)
+			}
+		}
+	}
+	return json.NewEncoder(body).Encode(data)
+}
+
+// execute executes the first defined template of the given names,
+// or the fallback if none of them are defined.
+func execute(body *bytes.Buffer, t *htmltemplate.Template, names []string, data *ErrorData, fallback *htmltemplate.Template) error {
+	for _, name := range names {
+		if found := t.Lookup(name); found != nil {
+			return found.Execute(body, data)
+		}
+	}
+	return fallback.Execute(body, data)
+}
+
+type errorPagesKey struct{}
+
+// Wrap returns a handler which serves h with the error pages available
+// to Error and which (if Intercept is set) replaces its error responses.
+func (p *ErrorPages) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := r.Context().Value(errorPagesKey{}).(*errorWriter)
+		ew := &errorWriter{
+			ResponseWriter: w,
+			pages:          p,
+			parent:         parent,
+		}
+		r = r.WithContext(context.WithValue(r.Context(), errorPagesKey{}, ew))
+		ew.req = r
+		h.ServeHTTP(ew, r)
+	})
+}
+
+// Error replies to the request with an error page from the innermost
+// ErrorPages which wrap the request or with http.Error if there are none.
+// If msg is empty, the status text is used.
+func Error(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	ew, ok := r.Context().Value(errorPagesKey{}).(*errorWriter)
+	if !ok {
+		if msg == "" {
+			msg = http.StatusText(code)
+		}
+		http.Error(w, msg, code)
+		return
+	}
+	ew.rendered()
+	ew.pages.Render(w, r, code, msg)
+}
+
+// An errorWriter intercepts error responses if its pages are so configured.
+type errorWriter struct {
+	http.ResponseWriter
+	pages  *ErrorPages
+	req    *http.Request
+	parent *errorWriter // enclosing error pages, if any
+
+	rendering bool // an error page is being written (not intercepted)
+	wrote     bool // the header has been written
+	replaced  bool // the response is being replaced (body is discarded)
+}
+
+// rendered marks this and all enclosing writers so that they do not
+// intercept the error page being written.
+func (w *errorWriter) rendered() {
+	for ; w != nil; w = w.parent {
+		w.rendering = true
+	}
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	if w.pages.Intercept && !w.rendering && code >= 400 {
+		w.replaced = true
+		w.rendered()
+		w.pages.Render(w.ResponseWriter, w.req, code, "")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *errorWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wrote {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, if the underlying ResponseWriter
+// supports it.  Error responses written to it are not intercepted.
+func (w *errorWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
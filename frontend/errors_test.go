@@ -0,0 +1,234 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	urlpkg "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/gofr/trie"
+)
+
+func TestErrorPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errorpages")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"404.html":   `<h1>missing {{.Path}}</h1>`,
+		"5xx.html":   `<h1>server error {{.Status}}: {{.Message}}</h1>`,
+		"error.html": `<h1>error {{.Status}}</h1>`,
+		"error.json": `{"code":{{.Status}},"message":{{json .Message}},"id":{{json .RequestID}}}`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+	pages, err := LoadErrorPages(dir)
+	if err != nil {
+		t.Fatalf("LoadErrorPages: %s", err)
+	}
+
+	backend := &Endpoint{
+		Name: "down",
+		RoundTripper: FuncTripper(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		}),
+		hosts: []*urlpkg.URL{{Scheme: "fake", Host: "hostname"}},
+	}
+
+	mux := trie.NewServeMux()
+	mux.Handle("example.com/down/", backend)
+	mux.HandleFunc("example.com/teapot", func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, http.StatusTeapot, "short and stout")
+	})
+	mux.Handle("example.com/none/", &Endpoint{Name: "none"})
+	mux.UseAt("example.com/", pages.Wrap)
+
+	tests := []struct {
+		desc   string
+		url    string
+		accept string
+		code   int
+		ctype  string
+		body   string
+	}{
+		{
+			desc:  "specific page",
+			url:   "http://example.com/down/page",
+			code:  http.StatusBadGateway,
+			ctype: "text/html; charset=utf-8",
+			body:  "<h1>server error 502: Backend Error</h1>",
+		},
+		{
+			desc:  "no backends",
+			url:   "http://example.com/none/page",
+			code:  http.StatusServiceUnavailable,
+			ctype: "text/html; charset=utf-8",
+			body:  "<h1>server error 503: Backend Unavailable</h1>",
+		},
+		{
+			desc:  "fallback page",
+			url:   "http://example.com/teapot",
+			code:  http.StatusTeapot,
+			ctype: "text/html; charset=utf-8",
+			body:  "<h1>error 418</h1>",
+		},
+		{
+			desc:   "json",
+			url:    "http://example.com/down/page",
+			accept: "application/json, text/html;q=0.5",
+			code:   http.StatusBadGateway,
+			ctype:  "application/json",
+			body:   `{"code":502,"message":"Backend Error","id":"abc123"}`,
+		},
+		{
+			desc:  "not intercepted",
+			url:   "http://example.com/missing",
+			code:  http.StatusNotFound,
+			ctype: "text/plain; charset=utf-8",
+			body:  "404 page not found\n",
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.url, nil)
+		r = WithRequestID(r, "abc123")
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("%s: code = %d, want %d", test.desc, got, want)
+		}
+		if got, want := w.Header().Get("Content-Type"), test.ctype; got != want {
+			t.Errorf("%s: content type = %q, want %q", test.desc, got, want)
+		}
+		if got, want := w.Body.String(), test.body; got != want {
+			t.Errorf("%s: body = %q, want %q", test.desc, got, want)
+		}
+	}
+}
+
+func TestErrorPagesIntercept(t *testing.T) {
+	outer := &ErrorPages{Intercept: true}
+	inner := &ErrorPages{Intercept: true}
+
+	tests := []struct {
+		desc    string
+		handler http.Handler
+		method  string // default GET
+		accept  string
+		code    int
+		body    string // substring
+		absent  string // must not appear
+	}{
+		{
+			desc: "backend error",
+			handler: outer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "11")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("stack trace"))
+			})),
+			code:   http.StatusInternalServerError,
+			body:   "<h1>500 Internal Server Error</h1>",
+			absent: "stack trace",
+		},
+		{
+			desc: "success",
+			handler: outer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			})),
+			code: http.StatusOK,
+			body: "hello",
+		},
+		{
+			desc:    "not found",
+			handler: outer.Wrap(http.NotFoundHandler()),
+			accept:  "application/json",
+			code:    http.StatusNotFound,
+			body:    `"status":404`,
+			absent:  "page not found",
+		},
+		{
+			desc: "nested",
+			handler: outer.Wrap(inner.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Error(w, r, http.StatusForbidden, "go away")
+			}))),
+			code: http.StatusForbidden,
+			body: "<p>go away</p>",
+		},
+		{
+			desc: "head",
+			handler: outer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})),
+			method: "HEAD",
+			code:   http.StatusServiceUnavailable,
+			absent: "<html>",
+		},
+		{
+			desc: "head error",
+			handler: outer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Error(w, r, http.StatusForbidden, "go away")
+			})),
+			method: "HEAD",
+			code:   http.StatusForbidden,
+			absent: "go away",
+		},
+	}
+
+	for _, test := range tests {
+		method := test.method
+		if method == "" {
+			method = "GET"
+		}
+		r, _ := http.NewRequest(method, "http://example.com/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, r)
+
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("%s: code = %d, want %d", test.desc, got, want)
+		}
+		if got := w.Body.String(); !strings.Contains(got, test.body) {
+			t.Errorf("%s: body = %q, want it to contain %q", test.desc, got, test.body)
+		}
+		if got := w.Body.String(); test.absent != "" && strings.Contains(got, test.absent) {
+			t.Errorf("%s: body = %q, must not contain %q", test.desc, got, test.absent)
+		}
+		if got := strings.Count(w.Body.String(), "<html>"); got > 1 {
+			t.Errorf("%s: rendered %d error pages, want at most 1", test.desc, got)
+		}
+	}
+}
+
+func TestErrorPagesFlushHijack(t *testing.T) {
+	checkFlushHijack(t, (&ErrorPages{Intercept: true}).Wrap)
+}
@@ -0,0 +1,114 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"net/http"
+	pathpkg "path"
+	"sort"
+	"strings"
+)
+
+// A HeaderFilter decides which headers are copied between a client
+// and a backend.
+//
+// The names in Allow and Strip may be header names or glob patterns
+// (see path.Match), and are matched without regard to case:
+//   X-Custom-*    - Matches X-Custom-Foo and x-custom-bar
+//   *             - Matches every header
+//
+// Each header is checked against Strip first and then against Allow.
+// Headers which match neither are not copied and are reported to the caller
+// so that they may be logged.  Allowed headers are renamed if their canonical
+// name (see http.CanonicalHeaderKey) is in Rename.  Finally, the headers in
+// Inject are set, replacing any copied values.
+//
+// A HeaderFilter must not be modified once it begins to serve traffic.
+type HeaderFilter struct {
+	Allow  []string
+	Strip  []string
+	Rename map[string]string
+	Inject http.Header
+}
+
+// DefaultRequestFilter is used for headers sent to a backend
+// if no other filter is specified.
+var DefaultRequestFilter = &HeaderFilter{
+	Allow: []string{
+		"Accept", "Accept-Language", "Content-Type",
+		"Authorization", "Referer", "User-Agent", "Cookie",
+		"ETag", "Cache-Control",
+		"If-Modified-Since", "If-Unmodified-Since", "If-Match", "If-None-Match",
+	},
+	Strip: []string{
+		"Accept-Charset", "Accept-Encoding", "Accept-Datetime",
+		"Content-MD5",
+		"Via", "Connection",
+		"X-Request-Id",              // set from RequestID
+		"Traceparent", "Tracestate", // set by Endpoint
+	},
+}
+
+// DefaultResponseFilter is used for headers returned from a backend
+// if no other filter is specified.  It passes everything except headers
+// which reveal details about the backend's software and the request ID
+// (so that the frontend's ID is the one returned to the client).
+var DefaultResponseFilter = &HeaderFilter{
+	Allow: []string{"*"},
+	Strip: []string{"Server", "X-Powered-By", "X-AspNet-Version", "X-Request-Id"},
+}
+
+// matchHeader reports whether the header name matches any of the patterns.
+func matchHeader(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range patterns {
+		if ok, _ := pathpkg.Match(strings.ToLower(pat), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Permit reports whether the named header would be copied by the filter
+// and the name under which it would be copied.
+func (f *HeaderFilter) Permit(name string) (to string, ok bool) {
+	if matchHeader(f.Strip, name) || !matchHeader(f.Allow, name) {
+		return "", false
+	}
+	to = http.CanonicalHeaderKey(name)
+	if renamed, ok := f.Rename[to]; ok {
+		to = http.CanonicalHeaderKey(renamed)
+	}
+	return to, true
+}
+
+// Copy copies the permitted headers from src into dst and returns the
+// (sorted) names of the headers which matched neither Allow nor Strip.
+func (f *HeaderFilter) Copy(dst, src http.Header) (blocked []string) {
+	for hdr, val := range src {
+		if to, ok := f.Permit(hdr); ok {
+			dst[to] = val
+			continue
+		}
+		if !matchHeader(f.Strip, hdr) {
+			blocked = append(blocked, hdr)
+		}
+	}
+	for hdr, val := range f.Inject {
+		dst[http.CanonicalHeaderKey(hdr)] = val
+	}
+	sort.Strings(blocked)
+	return blocked
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	tests := []struct {
+		desc    string
+		filter  *HeaderFilter
+		in      http.Header
+		out     http.Header
+		blocked []string
+	}{
+		{
+			desc:   "default request",
+			filter: DefaultRequestFilter,
+			in: http.Header{
+				"Accept":          {"text/html"},
+				"Etag":            {"xyzzy"},
+				"Accept-Encoding": {"gzip"},
+				"X-Unknown":       {"?"},
+			},
+			out: http.Header{
+				"Accept": {"text/html"},
+				"Etag":   {"xyzzy"},
+			},
+			blocked: []string{"X-Unknown"},
+		},
+		{
+			desc:   "default response",
+			filter: DefaultResponseFilter,
+			in: http.Header{
+				"Content-Type": {"text/html"},
+				"Server":       {"Apache/1.3"},
+				"X-Powered-By": {"PHP/4.0"},
+			},
+			out: http.Header{
+				"Content-Type": {"text/html"},
+			},
+		},
+		{
+			desc: "patterns",
+			filter: &HeaderFilter{
+				Allow: []string{"x-custom-*", "X-Other"},
+				Strip: []string{"X-Custom-Secret*"},
+			},
+			in: http.Header{
+				"X-Custom-Foo":      {"foo"},
+				"x-custom-bar":      {"bar"},
+				"X-Custom-Secret":   {"shh"},
+				"X-Custom-Secret-2": {"shh"},
+				"X-Other":           {"other"},
+				"X-Another":         {"another"},
+			},
+			out: http.Header{
+				"X-Custom-Foo": {"foo"},
+				"X-Custom-Bar": {"bar"},
+				"X-Other":      {"other"},
+			},
+			blocked: []string{"X-Another"},
+		},
+		{
+			desc: "rename and inject",
+			filter: &HeaderFilter{
+				Allow:  []string{"*"},
+				Rename: map[string]string{"X-Old-Name": "x-new-name"},
+				Inject: http.Header{"X-Static": {"value"}},
+			},
+			in: http.Header{
+				"X-Old-Name": {"renamed"},
+				"X-Static":   {"overwritten"},
+			},
+			out: http.Header{
+				"X-New-Name": {"renamed"},
+				"X-Static":   {"value"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		out := make(http.Header)
+		blocked := test.filter.Copy(out, test.in)
+		if got, want := out, test.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: copied %#v, want %#v", test.desc, got, want)
+		}
+		if got, want := blocked, test.blocked; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: blocked %q, want %q", test.desc, got, want)
+		}
+	}
+}
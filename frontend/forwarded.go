@@ -0,0 +1,148 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetForwarded sets the standard proxy headers in dst, which will be sent
+// to a backend on behalf of the (original) request r:
+//   Forwarded           - RFC 7239 element describing this hop
+//   X-Forwarded-For     - Set to the source IP of the client
+//   X-Forwarded-Host    - Set to the Host from the client
+//   X-Forwarded-Port    - Set to the port to which the client connected
+//   X-Forwarded-Proto   - Set to "http" or "https"
+//
+// If the request came from one of the trusted proxies, this hop is appended
+// to the Forwarded and X-Forwarded-For chains from the request and any
+// X-Forwarded-Host, -Port and -Proto values it provided are preserved.
+// Otherwise, any such headers in the request are ignored.
+func SetForwarded(dst http.Header, r *http.Request, trusted []*net.IPNet) {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	port := localPort(r, proto)
+
+	isTrusted := false
+	if ip := net.ParseIP(peer); ip != nil {
+		_, isTrusted = containsIP(trusted, ip)
+	}
+
+	// Standard chains
+	// Values which cannot be sent (because they contain control characters)
+	// are left out, or given as "unknown" for the required node
+	node, ok := forwardedNode(peer)
+	if !ok {
+		node = "unknown"
+	}
+	elem := "for=" + node
+	if host, ok := forwardedValue(r.Host); ok && r.Host != "" {
+		elem += ";host=" + host
+	}
+	elem += ";proto=" + proto
+	forwarded, xff := []string{elem}, []string{peer}
+	if isTrusted {
+		if prev := strings.Join(r.Header["Forwarded"], ", "); prev != "" {
+			forwarded = []string{prev + ", " + elem}
+		}
+		if prev := forwardedFor(r.Header); len(prev) > 0 {
+			xff = []string{strings.Join(append(prev, peer), ", ")}
+		}
+	}
+	dst["Forwarded"] = forwarded
+	dst["X-Forwarded-For"] = xff
+
+	// Original request information
+	original := func(hdr, value string) {
+		if prev := r.Header.Get(hdr); isTrusted && prev != "" {
+			value = prev
+		}
+		dst.Set(hdr, value)
+	}
+	original("X-Forwarded-Host", r.Host)
+	original("X-Forwarded-Port", port)
+	original("X-Forwarded-Proto", proto)
+}
+
+// localPort returns the port on which the request was received.
+func localPort(r *http.Request, proto string) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(r.Host); err == nil {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// forwardedNode formats an IP address as a node in a Forwarded header.
+func forwardedNode(ip string) (string, bool) {
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return forwardedValue(ip)
+}
+
+// forwardedValue returns s as a token if possible or a quoted-string
+// (RFC 7230, section 3.2.6) otherwise.  It returns false if s contains
+// control characters, which cannot be sent in either.
+func forwardedValue(s string) (string, bool) {
+	token := s != ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return "", false
+		}
+		if !isTokenChar(rune(c)) {
+			token = false
+		}
+	}
+	if token {
+		return s, true
+	}
+
+	// Only quotes and backslashes are escaped; other bytes (including
+	// tabs and those of UTF-8 sequences) are allowed as they are
+	quoted := make([]byte, 0, len(s)+2)
+	quoted = append(quoted, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			quoted = append(quoted, '\\')
+		}
+		quoted = append(quoted, s[i])
+	}
+	return string(append(quoted, '"')), true
+}
+
+func isTokenChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
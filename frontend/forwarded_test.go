@@ -0,0 +1,150 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSetForwarded(t *testing.T) {
+	trusted := []*net.IPNet{MustCIDR("10.0.0.0/8")}
+
+	tests := []struct {
+		desc   string
+		remote string
+		host   string
+		tls    bool
+		header http.Header
+		want   http.Header
+	}{
+		{
+			desc:   "direct",
+			remote: "1.2.3.4:5678",
+			host:   "example.com",
+			want: http.Header{
+				"Forwarded":         {"for=1.2.3.4;host=example.com;proto=http"},
+				"X-Forwarded-For":   {"1.2.3.4"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Port":  {"80"},
+				"X-Forwarded-Proto": {"http"},
+			},
+		},
+		{
+			desc:   "direct ipv6 with port",
+			remote: "[2001:db8::1]:5678",
+			host:   "example.com:8443",
+			tls:    true,
+			want: http.Header{
+				"Forwarded":         {`for="[2001:db8::1]";host="example.com:8443";proto=https`},
+				"X-Forwarded-For":   {"2001:db8::1"},
+				"X-Forwarded-Host":  {"example.com:8443"},
+				"X-Forwarded-Port":  {"8443"},
+				"X-Forwarded-Proto": {"https"},
+			},
+		},
+		{
+			desc:   "untrusted chain",
+			remote: "1.2.3.4:5678",
+			host:   "example.com",
+			header: http.Header{
+				"Forwarded":         {"for=6.6.6.6"},
+				"X-Forwarded-For":   {"6.6.6.6"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: http.Header{
+				"Forwarded":         {"for=1.2.3.4;host=example.com;proto=http"},
+				"X-Forwarded-For":   {"1.2.3.4"},
+				"X-Forwarded-Proto": {"http"},
+			},
+		},
+		{
+			desc:   "trusted chain",
+			remote: "10.1.1.1:5678",
+			host:   "example.com",
+			header: http.Header{
+				"Forwarded":         {"for=1.2.3.4;proto=https"},
+				"X-Forwarded-For":   {"1.2.3.4"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Port":  {"443"},
+			},
+			want: http.Header{
+				"Forwarded":         {"for=1.2.3.4;proto=https, for=10.1.1.1;host=example.com;proto=http"},
+				"X-Forwarded-For":   {"1.2.3.4, 10.1.1.1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Port":  {"443"},
+				"X-Forwarded-Proto": {"https"},
+			},
+		},
+		{
+			desc:   "control characters",
+			remote: "bad\x00addr",
+			host:   "example.com\r\nX-Evil: 1",
+			want: http.Header{
+				"Forwarded": {"for=unknown;proto=http"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/foo", nil)
+		if err != nil {
+			t.Fatalf("NewRequest(%q, %q, %#v): %s", "GET", "/foo", nil, err)
+		}
+		req.RemoteAddr = test.remote
+		req.Host = test.host
+		if test.header != nil {
+			req.Header = test.header
+		}
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		got := make(http.Header)
+		SetForwarded(got, req, trusted)
+		for k, v := range test.want {
+			if got, want := got[k], v; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: header[%q] = %#v, want %#v", test.desc, k, got, want)
+			}
+		}
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"example.com", "example.com", true},
+		{"", `""`, true},
+		{"example.com:8443", `"example.com:8443"`, true},
+		{"[2001:db8::1]", `"[2001:db8::1]"`, true},
+		{`say "hi"`, `"say \"hi\""`, true},
+		{`back\slash`, `"back\\slash"`, true},
+		{"tab\there", "\"tab\there\"", true},
+		{"caf\u00e9", "\"caf\u00e9\"", true},
+		{"new\nline", "", false},
+		{"del\x7f", "", false},
+	}
+	for _, test := range tests {
+		got, ok := forwardedValue(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("forwardedValue(%q) = %q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
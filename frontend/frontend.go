@@ -19,17 +19,22 @@ package frontend
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	urlpkg "net/url"
 	"strconv"
 	"sync"
 	"time"
 
 	"kylelemons.net/go/daemon"
+	"kylelemons.net/go/gofr/accesslog"
+	"kylelemons.net/go/gofr/trace"
+	"kylelemons.net/go/gofr/trie"
 )
 
 // An Endpoint handles routing requests to a backend.  The zero
@@ -41,20 +46,33 @@ import (
 //   Method              - Unmodified
 //   URL.Path            - Unmodified
 //   URL.RawQuery        - Unmodified
-//   Header              - Subject to whitelisting
+//   Header              - Subject to filtering (see RequestFilter)
 //   Body                - Subject to size limits
 //   ContentLength       - Subject to size limits
 //
 // The request contains the following standard headers:
 //   Host                - Set to the Host from the client
+//   Forwarded           - RFC 7239 element for this hop
 //   X-Forwarded-For     - Set to the source IP of the client
+//   X-Forwarded-Host    - Set to the Host from the client
+//   X-Forwarded-Port    - Set to the port to which the client connected
 //   X-Forwarded-Proto   - Set to "http" or "https"
+//   X-Request-Id        - Set to the ID of the request (see RequestIDs), if any
+//   Traceparent         - Set to the trace context (see Spans), if any
+//   Tracestate          - Copied from the client along with Traceparent
+//
+// If the client is one of the TrustedProxies, the Forwarded and
+// X-Forwarded-For chains are extended instead (see SetForwarded).
 //
 // The request also contains the following nonstandard headers:
 //   X-Gofr-Backend      - Set to the name of the bakend
 //   X-Gofr-Backend-Root - Set to the backend's root path
+//   X-Gofr-Stripped-Prefix - Set to the prefix stripped by a trie.ServeMux
+//                         mount (see trie.StrippedPrefix), if any
+//   <UserHeader>        - Set to the authenticated user (if configured)
 //
-// The response will have the following additional headers:
+// The response headers are subject to the Headers policy.  By default,
+// the response will have the following additional headers:
 //   X-Frame-Options     - Set to "sameorigin"
 //   X-XSS-Protection    - Set to "1; mode=block"
 //
@@ -66,9 +84,12 @@ import (
 //
 // A number of standard headers are stripped by default:
 //   Accept-Charset, Accept-Encoding, Accept-Datetime
-//   Content-MD5, Via, Connection
+//   Content-MD5, Via, Connection, X-Request-Id, Traceparent, Tracestate
 //
 // Any other headers will log a warning before being discarded.
+//
+// The Server, X-Powered-By, X-AspNet-Version and X-Request-Id headers are
+// stripped from backend responses by default.
 type Endpoint struct {
 	// Basic backend configuration
 	Name string // name of this backend (shown in __backends)
@@ -79,6 +100,35 @@ type Endpoint struct {
 	StripHeader   map[string]bool
 	BodySizeLimit int64
 
+	// Access, if non-nil, restricts which clients may use this backend.
+	Access *ACL
+
+	// Auth, if non-nil, requires clients to log in to use this backend.
+	// If UserHeader is also set, the authenticated username is sent to the
+	// backend in that header; it is never copied from the client.
+	Auth       *Auth
+	UserHeader string
+
+	// Proxies (such as load balancers) whose forwarding headers are trusted.
+	TrustedProxies []*net.IPNet
+
+	// Headers sent to and received from the backend are filtered.
+	// If these are nil, DefaultRequestFilter and DefaultResponseFilter
+	// are used.  AllowHeader and StripHeader take precedence.
+	RequestFilter  *HeaderFilter
+	ResponseFilter *HeaderFilter
+
+	// Headers is applied to all responses, including error responses.
+	// If it is nil, DefaultHeaders is used.
+	Headers *HeaderPolicy
+
+	// Spans, if non-nil, receives a span for each request proxied to the
+	// backend, with events for backend selection, connection, the first
+	// byte of the response and the end of the body copy.  The W3C trace
+	// context (traceparent and tracestate) from the client is propagated
+	// to the backend whether or not spans are recorded.
+	Spans trace.Exporter
+
 	// Transport for making requests.  HandleEndpoint will set
 	// this to http.DefaultTransport if it is nil.
 	http.RoundTripper
@@ -87,42 +137,71 @@ type Endpoint struct {
 	hosts []*urlpkg.URL
 }
 
+var errNoBackends = errors.New("no backends available")
+
 // ServeHTTP proxies the request to the backend.
 func (b *Endpoint) ServeHTTP(w http.ResponseWriter, original *http.Request) {
+	policy := b.Headers
+	if policy == nil {
+		policy = DefaultHeaders
+	}
+	w = policy.writer(w, original)
+
+	if b.Access != nil && !b.Access.check(w, original) {
+		return
+	}
+	if b.Auth != nil {
+		var ok bool
+		if original, ok = b.Auth.check(w, original); !ok {
+			return
+		}
+	}
+
 	start := time.Now()
 
+	// Start tracing
+	tc, traced := trace.FromHeader(original.Header)
+	var span *trace.Span
+	if b.Spans != nil {
+		span = trace.Start(b.Name, tc, b.Spans)
+		tc, traced = span.Context, true
+		defer span.End()
+		span.SetAttribute("http.method", original.Method)
+		span.SetAttribute("http.host", original.Host)
+		span.SetAttribute("http.path", original.URL.Path)
+		if id := RequestID(original); id != "" {
+			span.SetAttribute("request_id", id)
+		}
+	}
+
 	// Choose a backend
 	b.lock.RLock()
 	avail := len(b.hosts)
 	if avail == 0 {
-		daemon.Error.Printf("No backends available for %q", b.Name)
-		http.Error(w, "Backend Unavailable", http.StatusServiceUnavailable)
+		b.lock.RUnlock()
+		daemon.Error.Printf("%sNo backends available for %q", LogPrefix(original), b.Name)
+		span.SetError(errNoBackends)
+		span.SetAttribute("http.status", strconv.Itoa(http.StatusServiceUnavailable))
+		Error(w, original, http.StatusServiceUnavailable, "Backend Unavailable")
+		return
 	}
 	// TODO(kevlar): consistent hash (CRC32?) user to backend
 	url := *b.hosts[rand.Intn(avail)]
 	b.lock.RUnlock()
+	span.Event("backend selected")
+	span.SetAttribute("backend", url.Host)
 
 	// Copy the URL
 	url.Path = original.URL.Path
 	url.RawQuery = original.URL.RawQuery
 
-	// Compute for X- headers
-	ip, _, _ := net.SplitHostPort(original.RemoteAddr)
-	proto := "http"
-	if original.TLS != nil {
-		proto = "https"
+	// Copy headers (subject to filtering)
+	reqFilter := b.RequestFilter
+	if reqFilter == nil {
+		reqFilter = DefaultRequestFilter
 	}
-
-	// Set base headers
-	headers := http.Header{
-		"Host":                {original.Host},
-		"X-Forwarded-For":     {ip},
-		"X-Forwarded-Proto":   {proto},
-		"X-Gofr-Backend":      {b.Name},
-		"X-Gofr-Backend-Root": {b.Root},
-	}
-
-	// Copy headers (subject to whitelisting)
+	headers := make(http.Header)
+	filtered := make(http.Header)
 	for hdr, val := range original.Header {
 		if b.StripHeader[hdr] {
 			continue
@@ -131,29 +210,32 @@ func (b *Endpoint) ServeHTTP(w http.ResponseWriter, original *http.Request) {
 			headers[hdr] = val
 			continue
 		}
+		filtered[hdr] = val
+	}
+	for _, hdr := range reqFilter.Copy(headers, filtered) {
+		daemon.Verbose.Printf("%s%s: Blocking header %q: %q", LogPrefix(original), b.Name, hdr, filtered[hdr])
+	}
 
-		switch hdr {
-		// Pass through
-		case "Accept", "Accept-Language", "Content-Type":
-			fallthrough
-		case "Authorization", "Referer", "User-Agent", "Cookie":
-			fallthrough
-		case "ETag", "Etag", "Cache-Control":
-			fallthrough
-		case "If-Modified-Since", "If-Unmodified-Since", "If-Match", "If-None-Match":
-			headers[hdr] = val
+	// Set base headers
+	headers.Set("Host", original.Host)
+	SetForwarded(headers, original, b.TrustedProxies)
+	headers.Set("X-Gofr-Backend", b.Name)
+	headers.Set("X-Gofr-Backend-Root", b.Root)
+	if id := RequestID(original); id != "" {
+		headers.Set(RequestIDHeader, id)
+	}
+	if traced {
+		tc.Inject(headers)
+	}
+	if prefix := trie.StrippedPrefix(original); prefix != "" {
+		headers.Set("X-Gofr-Stripped-Prefix", prefix)
+	}
 
-		// Silently ignore
-		case "Accept-Charset", "Accept-Encoding", "Accept-Datetime":
-			fallthrough
-		case "Content-MD5":
-			fallthrough
-		case "Via", "Connection":
-			// do nothing
-
-		// Otherwise, log a warning
-		default:
-			daemon.Verbose.Printf("%s: Blocking header %q: %q", b.Name, hdr, val)
+	// Identify the authenticated user
+	if b.UserHeader != "" {
+		headers.Del(b.UserHeader)
+		if user := User(original); user != "" {
+			headers.Set(b.UserHeader, user)
 		}
 	}
 
@@ -182,34 +264,51 @@ func (b *Endpoint) ServeHTTP(w http.ResponseWriter, original *http.Request) {
 
 	// TODO(kevlar): prevent slow-send DoS
 
+	if span != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			ConnectStart:         func(_, _ string) { span.Event("connect") },
+			ConnectDone:          func(_, _ string, _ error) { span.Event("connected") },
+			WroteRequest:         func(httptrace.WroteRequestInfo) { span.Event("request written") },
+			GotFirstResponseByte: func() { span.Event("first byte") },
+		}))
+	}
+
 	// Issue the backend request
+	sent := time.Now()
 	resp, err := b.RoundTrip(req)
+	accesslog.FromRequest(original).SetBackend(b.Name, url.Host, time.Since(sent))
 	if err != nil {
-		daemon.Verbose.Printf("%s: routing %q to %q: backend error: %s", b.Name, original.URL, req.URL, err)
-
-		// TODO(kevlar): Better error pages
-		http.Error(w, "Backend Error", http.StatusInternalServerError)
+		daemon.Verbose.Printf("%s%s: routing %q to %q: backend error: %s", LogPrefix(original), b.Name, original.URL, req.URL, err)
+		span.SetError(err)
+		span.SetAttribute("http.status", strconv.Itoa(http.StatusBadGateway))
+		Error(w, original, http.StatusBadGateway, "Backend Error")
 		return
 	}
 	defer resp.Body.Close()
+	span.Event("response")
+	span.SetAttribute("http.status", strconv.Itoa(resp.StatusCode))
 
-	// Set some base response headers
-	w.Header().Set("X-Frame-Options", "sameorigin")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
-
-	// Copy the header
-	for k, v := range resp.Header {
-		w.Header()[k] = v
+	// Copy the header (subject to filtering)
+	respFilter := b.ResponseFilter
+	if respFilter == nil {
+		respFilter = DefaultResponseFilter
+	}
+	for _, hdr := range respFilter.Copy(w.Header(), resp.Header) {
+		daemon.Verbose.Printf("%s%s: Blocking response header %q: %q", LogPrefix(original), b.Name, hdr, resp.Header[hdr])
 	}
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy the response
-	if n, err := io.Copy(w, resp.Body); err != nil {
-		daemon.Verbose.Printf("%s: error writing response after %d bytes: %s", b.Name, n, err)
+	n, err := io.Copy(w, resp.Body)
+	span.Event("body copied")
+	span.SetAttribute("bytes", strconv.FormatInt(n, 10))
+	if err != nil {
+		daemon.Verbose.Printf("%s%s: error writing response after %d bytes: %s", LogPrefix(original), b.Name, n, err)
+		span.SetError(err)
 		return
 	}
 
-	daemon.Verbose.Printf("%s: Successfully routed request from %q to %q in %s", b.Name, original.URL, req.URL, time.Since(start))
+	daemon.Verbose.Printf("%s%s: Successfully routed request from %q to %q in %s", LogPrefix(original), b.Name, original.URL, req.URL, time.Since(start))
 }
 
 // A ServeMux allows handlers to be registered and can distribute
@@ -234,6 +333,8 @@ type Frontend struct {
 
 	lock      sync.RWMutex
 	endpoints []*Endpoint
+	handler   http.Handler // middleware added by Use, if any
+	end       *link        // end of the last middleware added
 }
 
 // New returns a frontend with a standard http.ServeMux and no DebugIPs.
@@ -243,47 +344,90 @@ func New() *Frontend {
 	}
 }
 
+// Use adds middleware which wraps every request served by the Frontend.
+// The first middleware added is the outermost.  Each middleware is called
+// once, when it is added.  Use must not be called once the Frontend has
+// begun to serve requests.
+//
+// The Wrap methods of ACL, Auth, HeaderPolicy, ErrorPages, RequestIDs and
+// accesslog.Logger can be used as middleware, as can trie.Middleware values.
+func (f *Frontend) Use(mw ...func(http.Handler) http.Handler) {
+	if len(mw) == 0 {
+		return
+	}
+	end := &link{f: f}
+	var h http.Handler = end
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	if f.end == nil {
+		f.handler = h
+	} else {
+		f.end.next = h
+	}
+	f.end = end
+}
+
+// A link continues a request from the end of the middleware added by one
+// call to Use to the middleware added by the next, or to the ServeMux.
+type link struct {
+	f    *Frontend
+	next http.Handler
+}
+
+func (l *link) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.next != nil {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+	l.f.ServeMux.ServeHTTP(w, r)
+}
+
+// ServeHTTP serves the request with the ServeMux, wrapped by any middleware.
+func (f *Frontend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.handler == nil {
+		f.ServeMux.ServeHTTP(w, r)
+		return
+	}
+	f.handler.ServeHTTP(w, r)
+}
+
 // HandleDebug registers the following handlers:
 //   /__backends   - backend information (ListBackends)
+//   /__routes     - routing tree, if the ServeMux has a ListRoutes method
+//                   (such as trie.ServeMux)
+//   /__explain    - routing decisions for ?method=...&url=..., if the
+//                   ServeMux has a ServeExplain method (such as trie.ServeMux)
 func (f *Frontend) HandleDebug() {
 	f.Handle("/__backends", f.Debug(http.HandlerFunc(f.ListBackends)))
-}
-
-// Debug serves 404 except for source IPs in the DebugIPs set.
-func (f *Frontend) Debug(h http.Handler) http.HandlerFunc {
-	blocked := func(r *http.Request, format string, args ...interface{}) {
-		err := fmt.Sprintf(format, args...)
-		daemon.Warning.Printf("[%s] BLOCKED debug access to %s: %s", r.RemoteAddr, r.URL.Path, err)
+	if lister, ok := f.ServeMux.(routeLister); ok {
+		f.Handle("/__routes", f.Debug(http.HandlerFunc(lister.ListRoutes)))
 	}
-	allowed := func(r *http.Request, format string, args ...interface{}) {
-		err := fmt.Sprintf(format, args...)
-		daemon.Verbose.Printf("[%s] Allowed debug access to %s: %s", r.RemoteAddr, r.URL.Path, err)
+	if explainer, ok := f.ServeMux.(routeExplainer); ok {
+		f.Handle("/__explain", f.Debug(http.HandlerFunc(explainer.ServeExplain)))
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		rawIP, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			blocked(r, "failed to split addr: %s", err)
-			http.NotFound(w, r)
-			return
-		}
+}
 
-		ip := net.ParseIP(rawIP)
-		if ip == nil {
-			blocked(r, "%q could not be parsed: %s", rawIP, err)
-			http.NotFound(w, r)
-			return
-		}
+// routeLister is implemented by ServeMuxes which can list their routes.
+type routeLister interface {
+	ListRoutes(w http.ResponseWriter, r *http.Request)
+}
 
-		for _, net := range f.DebugIPs {
-			if net.Contains(ip) {
-				allowed(r, "debug network %s", net)
-				h.ServeHTTP(w, r)
-				return
-			}
-		}
+// routeExplainer is implemented by ServeMuxes which can explain their routing.
+type routeExplainer interface {
+	ServeExplain(w http.ResponseWriter, r *http.Request)
+}
 
-		blocked(r, "%s is not in the debug IP range", ip)
-		http.NotFound(w, r)
+// Debug serves 404 except for source IPs in the DebugIPs set.  DebugIPs
+// must be set before Debug is called.
+func (f *Frontend) Debug(h http.Handler) http.HandlerFunc {
+	acl := &ACL{
+		Name:   "debug",
+		Allow:  f.DebugIPs,
+		Status: http.StatusNotFound,
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		acl.ServeHandler(w, r, h)
 	}
 }
 
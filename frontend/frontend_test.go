@@ -28,6 +28,8 @@ import (
 	"time"
 
 	"kylelemons.net/go/daemon"
+	"kylelemons.net/go/gofr/trace"
+	"kylelemons.net/go/gofr/trie"
 )
 
 func init() {
@@ -169,6 +171,142 @@ func TestEndpointRequest(t *testing.T) {
 	}
 }
 
+func TestEndpointMount(t *testing.T) {
+	var path, prefix string
+	b := &Endpoint{
+		Name: "blog",
+		Root: "/blog/",
+		RoundTripper: FuncTripper(func(inc *http.Request) (*http.Response, error) {
+			path, prefix = inc.URL.Path, inc.Header.Get("X-Gofr-Stripped-Prefix")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		}),
+		hosts: []*urlpkg.URL{{Scheme: "fake", Host: "hostname"}},
+	}
+
+	mux := trie.NewServeMux()
+	mux.Mount("/blog/", "/", b)
+	req, err := http.NewRequest("GET", "/blog/2013/post", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	req.RemoteAddr = "1.2.3.4:5678"
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got, want := path, "/2013/post"; got != want {
+		t.Errorf("backend path = %q, want %q", got, want)
+	}
+	if got, want := prefix, "/blog"; got != want {
+		t.Errorf("X-Gofr-Stripped-Prefix = %q, want %q", got, want)
+	}
+}
+
+func TestEndpointTrace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var sent http.Header
+	var spans []*trace.Span
+	b := &Endpoint{
+		Name: "test",
+		RoundTripper: FuncTripper(func(req *http.Request) (*http.Response, error) {
+			sent = req.Header
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("body")),
+			}, nil
+		}),
+		hosts: []*urlpkg.URL{{Scheme: "fake", Host: "hostname"}},
+	}
+
+	serve := func() {
+		req, err := http.NewRequest("GET", "/path", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		req.RemoteAddr = "1.2.3.4:5678"
+		req.Header.Set("Traceparent", parent)
+		req.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Without spans, the context is passed through
+	serve()
+	if got, want := sent.Get("Traceparent"), parent; got != want {
+		t.Errorf("untraced traceparent = %q, want %q", got, want)
+	}
+
+	// With spans, the backend sees the new span as its parent
+	b.Spans = trace.ExporterFunc(func(s *trace.Span) { spans = append(spans, s) })
+	serve()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("exported %d spans, want %d", got, want)
+	}
+	span := spans[0]
+	if got, want := sent.Get("Traceparent"), span.Context.Traceparent(); got != want {
+		t.Errorf("traced traceparent = %q, want %q", got, want)
+	}
+	if got, want := sent.Get("Tracestate"), "congo=t61rcWkgMzE"; got != want {
+		t.Errorf("tracestate = %q, want %q", got, want)
+	}
+	if got, want := span.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+		t.Errorf("span trace ID = %q, want %q", got, want)
+	}
+	if span.ParentID == nil || span.ParentID.String() != "00f067aa0ba902b7" {
+		t.Errorf("span parent = %v, want 00f067aa0ba902b7", span.ParentID)
+	}
+	var events []string
+	for _, e := range span.Events {
+		events = append(events, e.Name)
+	}
+	if got, want := events, []string{"backend selected", "response", "body copied"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	if got, want := span.Attributes["http.status"], "200"; got != want {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+	if got, want := span.Attributes["bytes"], "4"; got != want {
+		t.Errorf("bytes = %q, want %q", got, want)
+	}
+}
+
+func TestFrontendUse(t *testing.T) {
+	var order []string
+	built := map[string]int{}
+	tag := func(name string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			built[name]++
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	fe := New()
+	fe.Use(tag("first"), tag("second"))
+	fe.Use(tag("third"))
+	fe.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+
+	for i := 0; i < 2; i++ {
+		order = nil
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		fe.ServeHTTP(httptest.NewRecorder(), req)
+		if got, want := order, []string{"first", "second", "third", "handler"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("order = %q, want %q", got, want)
+		}
+	}
+	if got, want := built, map[string]int{"first": 1, "second": 1, "third": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware calls = %v, want %v", got, want)
+	}
+}
+
 func TestDebug(t *testing.T) {
 	fe := New()
 	fe.DebugIPs = LocalDebugIPs
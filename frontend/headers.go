@@ -0,0 +1,172 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A HeaderPolicy describes the headers to be added to or removed from
+// responses.  It is applied just before the response header is written,
+// so it sees (and can override) any headers set by the handler or copied
+// from a backend.  The rules are applied in the following order:
+//   Default   - Set only if the response does not already have a value
+//   Add       - Added alongside any existing values
+//   Override  - Set, replacing any existing values
+//   TLS       - Like Override, but only for requests made over HTTPS
+//   Remove    - Removed from the response
+//
+// The zero value is an empty policy.  The preset methods may be chained to
+// build up a policy, for example:
+//   (&HeaderPolicy{}).NoSniff().HSTS(365*24*time.Hour, true)
+//
+// A HeaderPolicy must not be modified once it begins to serve traffic.
+type HeaderPolicy struct {
+	Default  http.Header
+	Add      http.Header
+	Override http.Header
+	TLS      http.Header
+	Remove   []string
+}
+
+// DefaultHeaders is the policy used by an Endpoint if it has none.
+// Backends may provide their own values for any of these headers.
+var DefaultHeaders = &HeaderPolicy{
+	Default: http.Header{
+		"X-Frame-Options":  {"sameorigin"},
+		"X-Xss-Protection": {"1; mode=block"},
+	},
+}
+
+func override(h *http.Header, key, value string) {
+	if *h == nil {
+		*h = make(http.Header)
+	}
+	h.Set(key, value)
+}
+
+// HSTS adds a Strict-Transport-Security header to HTTPS responses.
+func (p *HeaderPolicy) HSTS(maxAge time.Duration, includeSubdomains bool) *HeaderPolicy {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	override(&p.TLS, "Strict-Transport-Security", value)
+	return p
+}
+
+// ContentSecurityPolicy adds a Content-Security-Policy header to all responses.
+func (p *HeaderPolicy) ContentSecurityPolicy(policy string) *HeaderPolicy {
+	override(&p.Override, "Content-Security-Policy", policy)
+	return p
+}
+
+// ReferrerPolicy adds a Referrer-Policy header to all responses.
+func (p *HeaderPolicy) ReferrerPolicy(policy string) *HeaderPolicy {
+	override(&p.Override, "Referrer-Policy", policy)
+	return p
+}
+
+// NoSniff adds "X-Content-Type-Options: nosniff" to all responses.
+func (p *HeaderPolicy) NoSniff() *HeaderPolicy {
+	override(&p.Override, "X-Content-Type-Options", "nosniff")
+	return p
+}
+
+// Apply applies the policy to the given response headers.
+func (p *HeaderPolicy) Apply(h http.Header, tls bool) {
+	for k, v := range p.Default {
+		if _, exist := h[k]; !exist {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+	for k, v := range p.Add {
+		h[k] = append(h[k], v...) // copies v, even when h[k] is nil
+	}
+	for k, v := range p.Override {
+		h[k] = append([]string(nil), v...)
+	}
+	if tls {
+		for k, v := range p.TLS {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+	for _, k := range p.Remove {
+		h.Del(k)
+	}
+}
+
+// Wrap returns a handler which applies the policy to all responses from h.
+func (p *HeaderPolicy) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(p.writer(w, r), r)
+	})
+}
+
+func (p *HeaderPolicy) writer(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	return &policyWriter{
+		ResponseWriter: w,
+		policy:         p,
+		tls:            r.TLS != nil,
+	}
+}
+
+// A policyWriter applies a HeaderPolicy when the response header is written.
+type policyWriter struct {
+	http.ResponseWriter
+	policy *HeaderPolicy
+	tls    bool
+	wrote  bool
+}
+
+func (w *policyWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.policy.Apply(w.Header(), w.tls)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *policyWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *policyWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wrote {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, if the underlying ResponseWriter
+// supports it.  The policy does not apply to anything written to it.
+func (w *policyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,201 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	policy := &HeaderPolicy{
+		Default: http.Header{
+			"X-Frame-Options": {"sameorigin"},
+		},
+		Add: http.Header{
+			"Vary": {"Cookie"},
+		},
+		Override: http.Header{
+			"Cache-Control": {"private"},
+		},
+		Remove: []string{"Server"},
+	}
+	policy.NoSniff().HSTS(24*time.Hour, true)
+
+	tests := []struct {
+		desc    string
+		tls     bool
+		handler http.HandlerFunc
+		want    http.Header // nil for "must not be present"
+	}{
+		{
+			desc: "empty response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("body"))
+			},
+			want: http.Header{
+				"X-Frame-Options":           {"sameorigin"},
+				"Vary":                      {"Cookie"},
+				"Cache-Control":             {"private"},
+				"X-Content-Type-Options":    {"nosniff"},
+				"Strict-Transport-Security": nil,
+			},
+		},
+		{
+			desc: "handler headers",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Frame-Options", "deny")
+				w.Header().Set("Vary", "Accept")
+				w.Header().Set("Cache-Control", "public")
+				w.Header().Set("Server", "secret/1.0")
+				w.WriteHeader(http.StatusOK)
+			},
+			want: http.Header{
+				"X-Frame-Options": {"deny"},
+				"Vary":            {"Accept", "Cookie"},
+				"Cache-Control":   {"private"},
+				"Server":          nil,
+			},
+		},
+		{
+			desc: "error response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			want: http.Header{
+				"X-Frame-Options":        {"sameorigin"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		{
+			desc: "hsts",
+			tls:  true,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("body"))
+			},
+			want: http.Header{
+				"Strict-Transport-Security": {"max-age=86400; includeSubDomains"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/foo", nil)
+		if err != nil {
+			t.Fatalf("NewRequest(%q, %q, %#v): %s", "GET", "/foo", nil, err)
+		}
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		policy.Wrap(test.handler).ServeHTTP(rec, req)
+		for k, v := range test.want {
+			if got, want := rec.HeaderMap[k], v; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: header[%q] = %#v, want %#v", test.desc, k, got, want)
+			}
+		}
+	}
+}
+
+func TestHeaderPolicyApplyCopies(t *testing.T) {
+	policy := &HeaderPolicy{
+		Default:  http.Header{"X-Frame-Options": {"sameorigin"}},
+		Add:      http.Header{"Vary": {"Cookie"}},
+		Override: http.Header{"Cache-Control": {"private"}},
+		TLS:      http.Header{"Strict-Transport-Security": {"max-age=86400"}},
+	}
+	want := http.Header{
+		"X-Frame-Options":           {"sameorigin"},
+		"Vary":                      {"Cookie"},
+		"Cache-Control":             {"private"},
+		"Strict-Transport-Security": {"max-age=86400"},
+	}
+
+	h := make(http.Header)
+	policy.Apply(h, true)
+	for _, v := range h {
+		v[0] = "changed"
+	}
+	for k, v := range want {
+		got := policy.Default[k]
+		for _, values := range []http.Header{policy.Add, policy.Override, policy.TLS} {
+			if got == nil {
+				got = values[k]
+			}
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("after changing the response header, policy[%q] = %#v, want %#v", k, got, v)
+		}
+	}
+}
+
+func TestHeaderPolicyFlushHijack(t *testing.T) {
+	policy := (&HeaderPolicy{}).NoSniff()
+	rec := checkFlushHijack(t, policy.Wrap)
+	if got, want := rec.HeaderMap.Get("X-Content-Type-Options"), "nosniff"; got != want {
+		t.Errorf("X-Content-Type-Options = %q, want %q", got, want)
+	}
+}
+
+// checkFlushHijack checks that handlers wrapped by wrap can flush their
+// responses and hijack their connections.  It returns the flushed response.
+func checkFlushHijack(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatalf("%T is not an http.Flusher", w)
+		}
+		f.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Errorf("response was not flushed")
+	}
+
+	server := httptest.NewServer(wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Errorf("%T is not an http.Hijacker", w)
+			return
+		}
+		conn, buf, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %s", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\nhijacked")
+		buf.Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if got, want := string(body), "hijacked"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	return rec
+}
@@ -0,0 +1,129 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request to backends and clients.
+const RequestIDHeader = "X-Request-Id"
+
+// MaxRequestIDLength is the longest request ID accepted from upstream.
+const MaxRequestIDLength = 128
+
+// RequestIDs assigns an ID to each request so that the access log, the
+// daemon log and the logs of the backends can be correlated.
+//
+// The ID is taken from the X-Request-Id header if the client is one of the
+// Trusted proxies and the ID is valid (a token of at most MaxRequestIDLength
+// characters); otherwise, a new one is generated.  The ID replaces any
+// X-Request-Id in the request, is available from RequestID, and is echoed
+// in the X-Request-Id header of the response.
+type RequestIDs struct {
+	Trusted []*net.IPNet
+
+	// Generate returns a new request ID.  If it is nil, NewRequestID is used.
+	Generate func() string
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random 128-bit request ID in hex.
+func NewRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("frontend: reading random request ID: " + err.Error())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// RequestID returns the ID assigned to the request, if any.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns a shallow copy of r with the given ID.
+func WithRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// validRequestID reports whether id is acceptable from upstream.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !isTokenChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// trustedClient reports whether the request came from one of the networks.
+func trustedClient(r *http.Request, trusted []*net.IPNet) bool {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	ip := net.ParseIP(peer)
+	if ip == nil {
+		return false
+	}
+	_, ok := containsIP(trusted, ip)
+	return ok
+}
+
+// Assign returns a copy of r with its request ID.
+func (ids *RequestIDs) Assign(r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) || !trustedClient(r, ids.Trusted) {
+		if ids.Generate != nil {
+			id = ids.Generate()
+		} else {
+			id = NewRequestID()
+		}
+	}
+	r = WithRequestID(r, id)
+	if r.Header = r.Header.Clone(); r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set(RequestIDHeader, id)
+	return r
+}
+
+// Wrap returns a handler which assigns request IDs before serving h.
+func (ids *RequestIDs) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = ids.Assign(r)
+		w.Header().Set(RequestIDHeader, RequestID(r))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// LogPrefix returns a prefix for log lines about the request which
+// identifies it, or "" if it has no ID.
+func LogPrefix(r *http.Request) string {
+	if id := RequestID(r); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package frontend
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	urlpkg "net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	ids := &RequestIDs{
+		Trusted:  []*net.IPNet{MustCIDR("10.0.0.0/8")},
+		Generate: func() string { return "generated" },
+	}
+
+	var backendID string
+	backend := &Endpoint{
+		Name: "test",
+		RoundTripper: FuncTripper(func(req *http.Request) (*http.Response, error) {
+			backendID = req.Header.Get(RequestIDHeader)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{RequestIDHeader: {"from-backend"}},
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		}),
+		hosts: []*urlpkg.URL{{Scheme: "fake", Host: "hostname"}},
+	}
+	h := ids.Wrap(backend)
+
+	tests := []struct {
+		desc   string
+		remote string
+		id     string // in the request
+		want   string
+	}{
+		{
+			desc:   "generated",
+			remote: "1.2.3.4:5678",
+			want:   "generated",
+		},
+		{
+			desc:   "untrusted",
+			remote: "1.2.3.4:5678",
+			id:     "spoofed",
+			want:   "generated",
+		},
+		{
+			desc:   "trusted",
+			remote: "10.1.2.3:5678",
+			id:     "upstream-1234",
+			want:   "upstream-1234",
+		},
+		{
+			desc:   "trusted but invalid",
+			remote: "10.1.2.3:5678",
+			id:     "bad id\r\nX-Injected: true",
+			want:   "generated",
+		},
+		{
+			desc:   "trusted but too long",
+			remote: "10.1.2.3:5678",
+			id:     strings.Repeat("x", MaxRequestIDLength+1),
+			want:   "generated",
+		},
+	}
+
+	for _, test := range tests {
+		backendID = ""
+		r, _ := http.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = test.remote
+		if test.id != "" {
+			r.Header.Set(RequestIDHeader, test.id)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if got, want := backendID, test.want; got != want {
+			t.Errorf("%s: backend got id %q, want %q", test.desc, got, want)
+		}
+		if got, want := w.Header().Get(RequestIDHeader), test.want; got != want {
+			t.Errorf("%s: response id = %q, want %q", test.desc, got, want)
+		}
+		if got, want := r.Header.Get(RequestIDHeader), test.id; got != want {
+			t.Errorf("%s: original request modified: id = %q, want %q", test.desc, got, want)
+		}
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if a == b {
+		t.Errorf("NewRequestID() returned %q twice", a)
+	}
+	if !validRequestID(a) {
+		t.Errorf("NewRequestID() = %q, which is not valid", a)
+	}
+}
@@ -19,26 +19,55 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	logpkg "log"
+	"net"
 	"net/http"
 	urlpkg "net/url"
 	"os"
+	"os/signal"
 	pathpkg "path"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"kylelemons.net/go/daemon"
+	"kylelemons.net/go/gofr/accesslog"
+	"kylelemons.net/go/gofr/frontend"
+	"kylelemons.net/go/gofr/proxyproto"
+	"kylelemons.net/go/gofr/redirect"
 	"kylelemons.net/go/gofr/static"
+	"kylelemons.net/go/gofr/trie"
 )
 
 var (
 	lameDuck = flag.Duration("lame-duck", 5*time.Second, "Amount of time to wait for lingering connections to close")
 
-	accessFile = flag.String("access", "access.log", "Path to the access log")
+	accessFile   = flag.String("access", "access.log", "Path to the access log")
+	accessFormat = flag.String("access-format", "gofr", `Access log format: "gofr", "gofr-extended" (gofr with the backend and timings), "common", "combined", "json" or a format string (see package accesslog)`)
+
+	accessMaxSize  = flag.Int64("access-max-size", 0, "Rotate the access log when it exceeds this many bytes (0 for no limit)")
+	accessInterval = flag.Duration("access-rotate", 0, "Rotate the access log at this interval, e.g. 24h (0 for never)")
+	accessCompress = flag.Bool("access-compress", false, "Compress rotated access logs with gzip")
+	accessKeep     = flag.Int("access-keep", 0, "Number of rotated access logs to keep (0 for all)")
+	accessQueue    = flag.Int("access-queue", 1024, "Number of access log lines to buffer for writing in the background (0 to write synchronously)")
+	accessDrop     = flag.Bool("access-drop", false, "Drop access log lines instead of blocking requests when the queue is full")
 
 	certFile = flag.String("cert", "/d/ssl/kylelemons.net.cert", "File containing SSL certificate(s)")
 	keyFile  = flag.String("key", "/d/ssl/kylelemons.net.key", "File containing SSL key")
 
+	proxyProto     = flag.Bool("proxy-protocol", false, "Accept PROXY protocol headers on incoming connections from -trusted-proxies")
+	trustedProxies = flag.String("trusted-proxies", "", "Comma-separated networks of load balancers whose forwarding headers are trusted")
+
+	login    = flag.String("login", "", "Comma-separated path prefixes which require a login (see -htpasswd)")
+	htpasswd = flag.String("htpasswd", "", "File containing bcrypt password hashes for -login (see frontend.LoadHtpasswd)")
+	private  = flag.String("private", "", "Comma-separated path prefixes which are only served to -private-nets")
+	privNets = flag.String("private-nets", "127.0.0.0/8,::1/128", "Comma-separated networks which may access -private paths")
+
+	redirects = flag.String("redirects", "", "File containing redirect rules for old URLs (see package redirect)")
+
+	errorPages      = flag.String("error-pages", "", "Directory containing error page templates (e.g. 404.html, 5xx.json)")
+	interceptErrors = flag.Bool("intercept-errors", false, "Replace error responses from backends with error pages")
+
 	logFile = daemon.LogFileFlag("log", 0644)
 	web     = daemon.ListenFlag("http", "tcp", ":80", "HTTP")
 	ssl     = daemon.ListenFlag("https", "tcp", ":443", "HTTPS")
@@ -46,8 +75,9 @@ var (
 )
 
 type Backend struct {
-	Name string
-	URL  *urlpkg.URL
+	Name    string
+	URL     *urlpkg.URL
+	Headers *frontend.HeaderFilter // if nil, frontend.DefaultRequestFilter is used
 }
 
 // Route routes the original request to this backend.
@@ -56,15 +86,18 @@ type Backend struct {
 //   Method            - Copied to request
 //   URL.Path          - Used to construct the backend path
 //   URL.RawQuery      - Used to construct the backend path
-//   Header            - Used as basis for backend headers (subject to whitelisting)
+//   Header            - Used as basis for backend headers (subject to b.Headers)
 //   Body              - Copied to request (subject to size limits)
 //   ContentLength     - Copied to request
 //
-// Route also provides the following headers:
+// Route provides the standard forwarding headers (see frontend.SetForwarded)
+// and also provides the following headers:
 //   X-Gofr-Forwarded-For       - Set to the RemoteAddr of the client
 //   X-Gofr-Requested-Host      - Set to the Host from the client
 //   X-Gofr-Backend             - Set to the name of the bakend the request is going to
 //   X-Gofr-Stripped-Prefix     - Set to the directory corresponding to /
+//   X-Request-Id               - Set to the ID of the request (see frontend.RequestIDs)
+//   X-Gofr-User                - Set to the user who logged in (see -login), if any
 func (b *Backend) Route(w http.ResponseWriter, original *http.Request, stripped string) error {
 	start := time.Now()
 
@@ -74,38 +107,24 @@ func (b *Backend) Route(w http.ResponseWriter, original *http.Request, stripped
 	url.RawQuery = original.URL.RawQuery
 
 	// Copy the headers
-	headers := http.Header{
-		"X-Gofr-Forwarded-For":   {original.RemoteAddr},
-		"X-Gofr-Requested-Host":  {original.Host},
-		"X-Gofr-Backend":         {b.Name},
-		"X-Gofr-Stripped-Prefix": {stripped},
-	}
-	for hdr, val := range original.Header {
-		switch hdr {
-		// Pass through
-		case "Accept", "Accept-Language", "Content-Type":
-			fallthrough
-		case "Authorization", "Referer", "User-Agent", "Cookie":
-			fallthrough
-		case "ETag", "Etag", "Cache-Control":
-			fallthrough
-		case "If-Modified-Since", "If-Unmodified-Since", "If-Match", "If-None-Match":
-			headers[hdr] = val
-
-		// Silently ignore
-		case "Accept-Charset", "Accept-Encoding", "Accept-Datetime":
-			fallthrough
-		case "Content-MD5":
-			fallthrough
-		case "Via", "Connection":
-			// do nothing
-
-		// Otherwise, log a warning
-		default:
-			// TODO(kevlar): configurable additional whitelisting
-
-			daemon.Verbose.Printf("%s: Blocking header %q: %q", b.Name, hdr, val)
-		}
+	filter := b.Headers
+	if filter == nil {
+		filter = frontend.DefaultRequestFilter
+	}
+	headers := make(http.Header)
+	for _, hdr := range filter.Copy(headers, original.Header) {
+		daemon.Verbose.Printf("%s%s: Blocking header %q: %q", frontend.LogPrefix(original), b.Name, hdr, original.Header[hdr])
+	}
+	frontend.SetForwarded(headers, original, trusted)
+	headers.Set("X-Gofr-Forwarded-For", original.RemoteAddr)
+	headers.Set("X-Gofr-Requested-Host", original.Host)
+	headers.Set("X-Gofr-Backend", b.Name)
+	headers.Set("X-Gofr-Stripped-Prefix", stripped)
+	if id := frontend.RequestID(original); id != "" {
+		headers.Set(frontend.RequestIDHeader, id)
+	}
+	if user := frontend.User(original); user != "" {
+		headers.Set("X-Gofr-User", user)
 	}
 
 	// Copy the request
@@ -122,29 +141,29 @@ func (b *Backend) Route(w http.ResponseWriter, original *http.Request, stripped
 	}
 
 	// Issue the backend request
+	sent := time.Now()
 	resp, err := http.DefaultTransport.RoundTrip(req) // TODO(kevlar): custom client with custom transport that sets max idle conns
+	accesslog.FromRequest(original).SetBackend(b.Name, url.Host, time.Since(sent))
 	if err != nil {
-		daemon.Verbose.Printf("%s: routing %q to %q: backend error: %s", b.Name, original.URL, req.URL, err)
-
-		// TODO(kevlar): Better error pages
-		http.Error(w, "Backend Unavailable", http.StatusServiceUnavailable)
+		daemon.Verbose.Printf("%s%s: routing %q to %q: backend error: %s", frontend.LogPrefix(original), b.Name, original.URL, req.URL, err)
+		frontend.Error(w, original, http.StatusServiceUnavailable, "Backend Unavailable")
 		return nil
 	}
 	defer resp.Body.Close()
 
 	// Copy the header
-	for k, v := range resp.Header {
-		w.Header()[k] = v
+	for _, hdr := range frontend.DefaultResponseFilter.Copy(w.Header(), resp.Header) {
+		daemon.Verbose.Printf("%s%s: Blocking response header %q: %q", frontend.LogPrefix(original), b.Name, hdr, resp.Header[hdr])
 	}
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy the response
 	if n, err := io.Copy(w, resp.Body); err != nil {
-		daemon.Verbose.Printf("%s: error writing response after %d bytes: %s", b.Name, n, err)
+		daemon.Verbose.Printf("%s%s: error writing response after %d bytes: %s", frontend.LogPrefix(original), b.Name, n, err)
 		return nil
 	}
 
-	daemon.Verbose.Printf("%s: Successfully routed request from %q to %q in %s", b.Name, original.URL, req.URL, time.Since(start))
+	daemon.Verbose.Printf("%s%s: Successfully routed request from %q to %q in %s", frontend.LogPrefix(original), b.Name, original.URL, req.URL, time.Since(start))
 	return nil
 }
 
@@ -168,6 +187,8 @@ func (r *rewriter) Route(w http.ResponseWriter, original *http.Request, stripped
 	return r.Backend.Route(w, original, pathpkg.Clean(stripped+r.Prefix))
 }
 
+func (r *rewriter) String() string { return "backend:" + r.Backend.Name }
+
 type redirector struct {
 	Strip, Replace string
 }
@@ -180,6 +201,8 @@ func (r *redirector) Route(w http.ResponseWriter, original *http.Request, stripp
 	return nil
 }
 
+func (r *redirector) String() string { return "redirect:" + r.Strip }
+
 type handler struct {
 	Prefix string
 	http.Handler
@@ -190,9 +213,78 @@ func (h *handler) Route(w http.ResponseWriter, r *http.Request, stripped string)
 	return nil
 }
 
+func (h *handler) String() string { return "handler:" + h.Prefix }
+
 type Frontend struct {
-	Backends map[string]*Backend
-	Routes   map[string]Router
+	Backends  map[string]*Backend
+	Routes    map[string]Router
+	Redirects *redirect.Table // consulted before Routes, if non-nil
+
+	// guards restrict access to the paths under their prefixes (see Protect).
+	guards map[string]*guard
+}
+
+// A guard restricts access to the paths under a prefix.
+type guard struct {
+	acl     *frontend.ACL
+	auth    *frontend.Auth
+	handler http.Handler // serves requests which pass this and all enclosing guards
+}
+
+// under returns true if the cleaned path is prefix or is beneath it.
+func under(path, prefix string) bool {
+	if prefix == "/" || path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+"/")
+}
+
+// Protect restricts access to the paths under prefix (on path segment
+// boundaries, with or without a trailing slash) to clients permitted by
+// acl and authenticated by auth (either of which may be nil).  Every
+// guard whose prefix encloses a request's path applies to it, from the
+// shortest prefix to the longest.
+func (fe *Frontend) Protect(prefix string, acl *frontend.ACL, auth *frontend.Auth) {
+	prefix = pathpkg.Clean("/" + prefix)
+	if _, exist := fe.guards[prefix]; exist {
+		daemon.Fatal.Printf("a guard for %q already exists", prefix)
+	}
+
+	if fe.guards == nil {
+		fe.guards = make(map[string]*guard)
+	}
+	fe.guards[prefix] = &guard{acl: acl, auth: auth}
+
+	// Rebuild each guard's handler, since the new guard may enclose it
+	for p, g := range fe.guards {
+		var enclosing []string
+		for q := range fe.guards {
+			if under(p, q) {
+				enclosing = append(enclosing, q)
+			}
+		}
+		sort.Slice(enclosing, func(i, j int) bool { return len(enclosing[i]) < len(enclosing[j]) })
+
+		// Apply each ACL and Auth only once, where it first appears
+		// (Auth removes the credentials it has checked)
+		var layers []func(http.Handler) http.Handler
+		seenACL, seenAuth := map[*frontend.ACL]bool{}, map[*frontend.Auth]bool{}
+		for _, q := range enclosing {
+			if acl := fe.guards[q].acl; acl != nil && !seenACL[acl] {
+				seenACL[acl] = true
+				layers = append(layers, acl.Wrap)
+			}
+			if auth := fe.guards[q].auth; auth != nil && !seenAuth[auth] {
+				seenAuth[auth] = true
+				layers = append(layers, auth.Wrap)
+			}
+		}
+		var h http.Handler = http.HandlerFunc(fe.serve)
+		for i := len(layers) - 1; i >= 0; i-- {
+			h = layers[i](h)
+		}
+		g.handler = h
+	}
 }
 
 func (fe *Frontend) Handle(prefix string, h http.Handler) {
@@ -204,6 +296,7 @@ func (fe *Frontend) Handle(prefix string, h http.Handler) {
 		fe.Routes = make(map[string]Router)
 	}
 	fe.Routes[prefix] = &handler{
+		Prefix:  prefix,
 		Handler: h,
 	}
 }
@@ -261,61 +354,51 @@ func (fe *Frontend) AddRoute(prefix string, backend, backendPath string) {
 	}
 }
 
-type rwlogger struct {
-	code  int
-	bytes int
-	http.ResponseWriter
-}
-
-func (w *rwlogger) WriteHeader(code int) {
-	w.code = code
-	w.ResponseWriter.WriteHeader(code)
-}
+func (fe *Frontend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Clean path
+	path := pathpkg.Clean(r.URL.Path)
+	r.URL.Path = path
 
-func (w *rwlogger) Write(b []byte) (int, error) {
-	n, err := w.ResponseWriter.Write(b)
-	w.bytes += n
-	return n, err
+	// The innermost guard also applies all of the enclosing ones
+	var longest string
+	var inner *guard
+	for prefix, g := range fe.guards {
+		if under(path, prefix) && len(prefix) > len(longest) {
+			longest, inner = prefix, g
+		}
+	}
+	if inner != nil {
+		inner.handler.ServeHTTP(w, r)
+		return
+	}
+	fe.serve(w, r)
 }
 
-func (fe *Frontend) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	w := &rwlogger{200, 0, rw}
-	start := time.Now()
-	defer func() {
-		now := start.Format("[02/Jan/2006:15:04:05 -0700]")
+// serve serves a request which has passed any guards.
+func (fe *Frontend) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
 
-		// access log format: "%h %l %u %t \"%r\" %>s %b"
-		addr := r.RemoteAddr
-		if colon := strings.Index(addr, ":"); colon >= 0 {
-			addr = addr[:colon]
-		}
-		user := "-"
-		if r.URL.User != nil {
-			user = r.URL.User.Username()
+	if fe.Redirects != nil {
+		if target, code, ok := fe.Redirects.Lookup(r.URL); ok {
+			http.Redirect(w, r, target, code)
+			return
 		}
-		firstLine := fmt.Sprintf("%s %s %s", r.Method, r.URL, r.Proto)
-		bytes := "-"
-		if w.bytes > 0 {
-			bytes = fmt.Sprintf("%d", w.bytes)
-		}
-		full := r.URL.Path
-		if r.Host != "" {
-			u := *r.URL
-			u.Host = r.Host
-			u.Scheme = "http"
-			if r.TLS != nil {
-				u.Scheme = "https"
-			}
-			full = u.String()
-		}
-		useragent := r.Header.Get("User-Agent")
-		access.Printf("%s - %s %s %q %d %s %q %q", addr, user, now, firstLine, w.code, bytes, full, useragent)
-	}()
+	}
 
-	// Clean path
-	path := pathpkg.Clean(r.URL.Path)
-	r.URL.Path = path
+	route := fe.Lookup(path)
+	if route == nil {
+		frontend.Error(w, r, http.StatusNotFound, "")
+		return
+	}
+
+	if err := route.Route(w, r, ""); err != nil {
+		daemon.Error.Printf("%sinternal error: %s", frontend.LogPrefix(r), err)
+	}
+}
 
+// Lookup returns the route with the longest prefix of path, or nil if
+// there is none.  Redirects are not consulted.
+func (fe *Frontend) Lookup(path string) Router {
 	var longest string
 	var route Router
 
@@ -327,18 +410,67 @@ func (fe *Frontend) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			longest, route = prefix, r
 		}
 	}
+	return route
+}
 
-	if route == nil {
-		// TODO(kevlar): better error pages
-		http.NotFound(w, r)
-		return
+// routeHandler adapts a Router for use in a trie.Trace.
+type routeHandler struct {
+	Router
+}
+
+func (h routeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.Route(w, r, ""); err != nil {
+		daemon.Error.Printf("%sinternal error: %s", frontend.LogPrefix(r), err)
 	}
+}
 
-	if err := route.Route(w, r, ""); err != nil {
-		daemon.Error.Printf("internal error: %s", err)
+func (h routeHandler) String() string { return fmt.Sprint(h.Router) }
+
+// Explain reports which route would serve a request, in the same form as
+// trie.ServeMux.Explain.  Handlers are described as "backend:NAME",
+// "redirect:PREFIX" or "handler:PREFIX", or "redirect CODE to URL" if
+// a redirect rule matches.
+func (fe *Frontend) Explain(method, url string) (*trie.Trace, error) {
+	u, err := urlpkg.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	trace := &trie.Trace{
+		Method: method,
+		URL:    url,
+	}
+	path := pathpkg.Clean(u.Path)
+	u.Path = path
+
+	if fe.Redirects != nil {
+		if target, code, ok := fe.Redirects.Lookup(u); ok {
+			trace.Steps = append(trace.Steps, fmt.Sprintf("redirect rule matched %q", path))
+			trace.Handler = redirectRule{
+				Handler: http.RedirectHandler(target, code),
+				desc:    fmt.Sprintf("redirect %d to %s", code, target),
+			}
+			return trace, nil
+		}
+	}
+
+	route := fe.Lookup(path)
+	if route == nil {
+		trace.Steps = append(trace.Steps, fmt.Sprintf("no route matched %q", path))
+		return trace, nil
 	}
+	trace.Steps = append(trace.Steps, fmt.Sprintf("route %s matched %q", route, path))
+	trace.Handler = routeHandler{route}
+	return trace, nil
 }
 
+// redirectRule describes the handler for a redirect rule.
+type redirectRule struct {
+	http.Handler
+	desc string
+}
+
+func (r redirectRule) String() string { return r.desc }
+
 func setup() *Frontend {
 	fe := new(Frontend)
 	fe.AddRedirect("/", "/blog")
@@ -355,25 +487,177 @@ func setup() *Frontend {
 	return fe
 }
 
-var access = logpkg.New(os.Stderr, "", 0)
+// trusted holds the networks from -trusted-proxies.
+var trusted []*net.IPNet
+
+// protect adds the guards configured by -login and -private to fe.
+func protect(fe *Frontend) error {
+	var auth *frontend.Auth
+	if *login != "" {
+		if *htpasswd == "" {
+			return fmt.Errorf("-login requires -htpasswd")
+		}
+		users, err := frontend.LoadHtpasswd(*htpasswd)
+		if err != nil {
+			return fmt.Errorf("-htpasswd: %s", err)
+		}
+		auth = &frontend.Auth{Realm: "gofr", Users: users}
+	}
+	var acl *frontend.ACL
+	if *private != "" {
+		nets, err := parseCIDRs(*privNets)
+		if err != nil {
+			return fmt.Errorf("-private-nets: %s", err)
+		}
+		acl = &frontend.ACL{
+			Name:           "private",
+			Allow:          nets,
+			Status:         http.StatusNotFound,
+			TrustedProxies: trusted,
+		}
+	}
+
+	guards := make(map[string]*guard)
+	add := func(list string, fn func(g *guard)) {
+		for _, prefix := range strings.Split(list, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix == "" {
+				continue
+			}
+			prefix = pathpkg.Clean("/" + prefix)
+			if guards[prefix] == nil {
+				guards[prefix] = new(guard)
+			}
+			fn(guards[prefix])
+		}
+	}
+	add(*login, func(g *guard) { g.auth = auth })
+	add(*private, func(g *guard) { g.acl = acl })
+	for prefix, g := range guards {
+		fe.Protect(prefix, g.acl, g.auth)
+		daemon.Info.Printf("Protecting %s (login: %v, private: %v)", prefix, g.auth != nil, g.acl != nil)
+	}
+	return nil
+}
+
+// parseCIDRs parses a comma-separated list of networks.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "logstat" {
+		if err := logstat(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "logstat: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "replay" {
+		if err := replay(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	daemon.LogLevel = daemon.Verbose
 	daemon.LameDuck = *lameDuck
 
-	accessOut, err := os.OpenFile(*accessFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	var err error
+	if trusted, err = parseCIDRs(*trustedProxies); err != nil {
+		daemon.Fatal.Printf("-trusted-proxies: %s", err)
+	}
+	if *proxyProto && len(trusted) == 0 {
+		daemon.Fatal.Printf("-proxy-protocol requires -trusted-proxies (the load balancers allowed to send PROXY headers)")
+	}
+
+	format, err := accesslog.Lookup(*accessFormat)
+	if err != nil {
+		daemon.Fatal.Printf("-access-format: %s", err)
+	}
+	accessOut, err := accesslog.OpenFile(*accessFile)
 	if err != nil {
 		daemon.Fatal.Printf("open access log: %s", err)
 	}
 	defer accessOut.Close()
+	accessOut.MaxSize = *accessMaxSize
+	accessOut.Interval = *accessInterval
+	accessOut.Compress = *accessCompress
+	accessOut.MaxBackups = *accessKeep
+
+	// Reopen the access log on SIGUSR1 (e.g. after logrotate moves it)
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR1)
+	go func() {
+		for range reopen {
+			if err := accessOut.Reopen(); err != nil {
+				daemon.Error.Printf("reopen access log: %s", err)
+				continue
+			}
+			daemon.Info.Printf("Reopened access log %s", *accessFile)
+		}
+	}()
 
 	daemon.Info.Printf("Writing access log to %s", *accessFile)
-	access = logpkg.New(accessOut, "", 0)
+	access := accesslog.New(accessOut, format)
+	if *accessQueue > 0 {
+		overflow := accesslog.Block
+		if *accessDrop {
+			overflow = accesslog.Drop
+		}
+		async := accesslog.NewAsyncWriter(accessOut, *accessQueue, overflow)
+		access = accesslog.New(async, format)
+
+		// Flush the queue when entering lame duck mode; the lines
+		// logged after that are written synchronously.
+		go func() {
+			<-daemon.Lamed
+			async.Close()
+			if n := async.Dropped(); n > 0 {
+				daemon.Warning.Printf("Dropped %d access log lines", n)
+			}
+		}()
+	}
 
 	// DefaultMaxIdleConnsPerHost = 32
 	fe := setup()
+	if *redirects != "" {
+		if fe.Redirects, err = redirect.LoadFile(*redirects); err != nil {
+			daemon.Fatal.Printf("redirects: %s", err)
+		}
+		daemon.Info.Printf("Loaded %d redirect rules from %s", fe.Redirects.Len(), *redirects)
+	}
+	if err := protect(fe); err != nil {
+		daemon.Fatal.Printf("%s", err)
+	}
+
+	var handler http.Handler = fe
+	if *errorPages != "" || *interceptErrors {
+		pages := new(frontend.ErrorPages)
+		if *errorPages != "" {
+			if pages, err = frontend.LoadErrorPages(*errorPages); err != nil {
+				daemon.Fatal.Printf("error pages: %s", err)
+			}
+			daemon.Info.Printf("Loaded error pages from %s", *errorPages)
+		}
+		pages.Intercept = *interceptErrors
+		handler = pages.Wrap(fe)
+	}
+	ids := &frontend.RequestIDs{Trusted: trusted}
+	handler = ids.Wrap(access.Wrap(handler))
 
 	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
 	if err != nil {
@@ -401,18 +685,22 @@ func main() {
 	if err != nil {
 		daemon.Fatal.Printf("listen(%q): %s", ssl, err)
 	}
+	if *proxyProto {
+		httpSock = proxyproto.NewListener(httpSock, trusted)
+		httpsRawSock = proxyproto.NewListener(httpsRawSock, trusted)
+	}
 	httpsSock := tls.NewListener(httpsRawSock, tlsConfig)
 
 	// Drop privileges
 	privs.Drop()
 
 	go func() {
-		if err := http.Serve(httpSock, fe); err != nil && err != daemon.ErrStopped {
+		if err := http.Serve(httpSock, handler); err != nil && err != daemon.ErrStopped {
 			daemon.Fatal.Printf("http: %s", err)
 		}
 	}()
 	go func() {
-		if err := http.Serve(httpsSock, fe); err != nil && err != daemon.ErrStopped {
+		if err := http.Serve(httpsSock, handler); err != nil && err != daemon.ErrStopped {
 			daemon.Fatal.Printf("https: %s", err)
 		}
 	}()
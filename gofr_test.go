@@ -18,18 +18,30 @@ import (
 	"flag"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"kylelemons.net/go/gofr/frontend"
+	"kylelemons.net/go/gofr/trie"
 )
 
 var (
 	live = flag.String("live", "", `If specified, tests live server (e.g. "http://example.com/")`)
 )
 
+// TestURLs requests each URL in urls.txt from a server, either the one
+// given by -live or one running the routes from setup with stub backends,
+// and checks the status and Location of each response.  The handler cannot
+// be observed over HTTP, so it is not checked (see TestRouting); without
+// -live, URLs for which only the handler is given (static files, which
+// are only on the live server) are skipped.
 func TestURLs(t *testing.T) {
-	data, err := ioutil.ReadFile("urls.txt")
+	exps, err := trie.LoadExpectations("urls.txt")
 	if err != nil {
 		t.Fatalf("load urls: %s", err)
 	}
@@ -39,32 +51,37 @@ func TestURLs(t *testing.T) {
 	base := *live
 	if base == "" {
 		fe := setup()
+		stop, err := stubBackends(fe)
+		if err != nil {
+			t.Fatalf("stubBackends: %s", err)
+		}
+		defer stop()
 		ts := httptest.NewServer(fe)
 		base = ts.URL
 		defer ts.Close()
 	}
+	base = strings.TrimSuffix(base, "/")
 	t.Logf("Testing against %q...", base)
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
-			continue
-		}
-
-		pieces := strings.Fields(line)
-		if len(pieces) != 2 {
-			t.Errorf("bad URL line %q", line)
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, e := range exps {
+		want := e
+		want.Handler = ""
+		if *live == "" && e.Handler != "" && e.Status == 0 && e.Location == "" {
 			continue
 		}
-		method, path := pieces[0], base+pieces[1]
+		method, path := e.Method, base+e.URL
 
 		req, err := http.NewRequest(method, path, nil)
 		if err != nil {
-			t.Errorf("bad line %q: %s", line, err)
+			t.Errorf("line %d: bad request: %s", e.Line, err)
 			continue
 		}
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			t.Errorf("do(%q, %q): %s", method, path, err)
 			continue
@@ -75,8 +92,14 @@ func TestURLs(t *testing.T) {
 		codes[resp.StatusCode]++
 		total++
 
-		if resp.StatusCode/100 >= 4 {
-			t.Errorf("%d %s - %s", resp.StatusCode, http.StatusText(resp.StatusCode), line)
+		got := trie.Expectation{
+			Method:   e.Method,
+			URL:      e.URL,
+			Status:   resp.StatusCode,
+			Location: resp.Header.Get("Location"),
+		}
+		if err := want.Check(got); err != nil {
+			t.Errorf("line %d: %s", e.Line, err)
 			continue
 		}
 		good++
@@ -87,3 +110,128 @@ func TestURLs(t *testing.T) {
 		t.Logf("   %3d x %3d %s", count, code, http.StatusText(code))
 	}
 }
+
+func TestProtect(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+	auth := &frontend.Auth{Realm: "test", Users: map[string][]byte{"alice": hash}}
+	acl := &frontend.ACL{Name: "private", Allow: []*net.IPNet{frontend.MustCIDR("10.0.0.0/8")}, Status: 404}
+
+	fe := new(Frontend)
+	fe.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "user="+frontend.User(r))
+	}))
+	fe.Protect("/private", acl, nil)
+	fe.Protect("/private/login", acl, auth)
+	fe.Protect("/login", nil, auth)
+
+	tests := []struct {
+		path   string
+		remote string
+		basic  bool
+		code   int
+		body   string
+	}{
+		{"/public", "1.2.3.4:5", false, 200, "user="},
+		{"/private/x", "1.2.3.4:5", false, 404, ""},
+		{"/private/x", "10.1.2.3:5", false, 200, "user="},
+		{"/private/login", "10.1.2.3:5", false, 401, ""},
+		{"/private/login", "10.1.2.3:5", true, 200, "user=alice"},
+		{"/private/login", "1.2.3.4:5", true, 404, ""},
+		{"/login", "1.2.3.4:5", true, 200, "user=alice"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		req.RemoteAddr = test.remote
+		if test.basic {
+			req.SetBasicAuth("alice", "hunter2")
+		}
+		rec := httptest.NewRecorder()
+		fe.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%s from %s: code = %d, want %d", test.path, test.remote, rec.Code, test.code)
+			continue
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s from %s: body = %q, want %q", test.path, test.remote, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestProtectFlags(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %s", err)
+	}
+	file, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("alice:" + string(hash) + "\n")
+	file.Close()
+
+	defer func(l, h, p, n string) { *login, *htpasswd, *private, *privNets = l, h, p, n }(*login, *htpasswd, *private, *privNets)
+	*login, *htpasswd = "/staging/admin,/docs/", file.Name()
+	*private, *privNets = "/staging/,/priv,/docs", "10.0.0.0/8"
+
+	fe := new(Frontend)
+	fe.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "user="+frontend.User(r))
+	}))
+	if err := protect(fe); err != nil {
+		t.Fatalf("protect: %s", err)
+	}
+
+	tests := []struct {
+		path   string
+		remote string
+		basic  bool
+		code   int
+		body   string
+	}{
+		// Nested guards all apply
+		{"/staging/admin", "1.2.3.4:5", true, 404, ""},
+		{"/staging/admin/x", "1.2.3.4:5", true, 404, ""},
+		{"/staging/admin", "10.1.2.3:5", false, 401, ""},
+		{"/staging/admin", "10.1.2.3:5", true, 200, "user=alice"},
+		{"/staging/other", "10.1.2.3:5", false, 200, "user="},
+		{"/docs/x", "10.1.2.3:5", true, 200, "user=alice"},
+		{"/docs/x", "1.2.3.4:5", true, 404, ""},
+
+		// With and without a trailing slash
+		{"/staging", "1.2.3.4:5", false, 404, ""},
+		{"/staging/", "1.2.3.4:5", false, 404, ""},
+		{"/priv/", "1.2.3.4:5", false, 404, ""},
+		{"/docs", "10.1.2.3:5", false, 401, ""},
+
+		// Only on path segment boundaries
+		{"/private-docs", "1.2.3.4:5", false, 200, "user="},
+		{"/stagingarea", "1.2.3.4:5", false, 200, "user="},
+		{"/staging/administrator", "10.1.2.3:5", false, 200, "user="},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		req.RemoteAddr = test.remote
+		if test.basic {
+			req.SetBasicAuth("alice", "hunter2")
+		}
+		rec := httptest.NewRecorder()
+		fe.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%s from %s: code = %d, want %d", test.path, test.remote, rec.Code, test.code)
+			continue
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s from %s: body = %q, want %q", test.path, test.remote, rec.Body.String(), test.body)
+		}
+	}
+}
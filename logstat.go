@@ -0,0 +1,112 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"kylelemons.net/go/gofr/accesslog"
+)
+
+// logstat implements the "logstat" subcommand, which summarizes the
+// requests in one or more access logs:
+//   gofr logstat [-format gofr] [-since 24h] [-top 10] [access.log ...]
+// If no files are given, the file named by -access is read.  Latencies and
+// per-backend stats are only available from logs in the "gofr-extended" or
+// "json" format; for other formats a warning is printed and they are omitted.
+func logstat(args []string) error {
+	fs := flag.NewFlagSet("logstat", flag.ContinueOnError)
+	format := fs.String("format", *accessFormat, `Format of the logs: "common", "combined", "gofr", "gofr-extended" or "json" (only the last two record latencies and backends)`)
+	since := fs.Duration("since", 0, "Only include requests in this long before now or -end (0 for all)")
+	start := fs.String("start", "", "Only include requests at or after this time (RFC 3339)")
+	end := fs.String("end", "", "Only include requests before this time (RFC 3339)")
+	top := fs.Int("top", 10, "Number of paths, user agents, etc to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var from, until time.Time
+	var err error
+	if *end != "" {
+		if until, err = time.Parse(time.RFC3339, *end); err != nil {
+			return fmt.Errorf("-end: %s", err)
+		}
+	}
+	if *start != "" {
+		if from, err = time.Parse(time.RFC3339, *start); err != nil {
+			return fmt.Errorf("-start: %s", err)
+		}
+	}
+	if *since > 0 {
+		base := until
+		if base.IsZero() {
+			base = time.Now()
+		}
+		from = base.Add(-*since)
+	}
+
+	if !accesslog.Timed(*format) {
+		fmt.Fprintf(os.Stderr, "logstat: warning: logs in format %q do not record latencies or backends; use -access-format=gofr-extended to log them\n", *format)
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{*accessFile}
+	}
+
+	stats := accesslog.NewStats()
+	for _, file := range files {
+		if err := readStats(stats, file, *format, from, until); err != nil {
+			return err
+		}
+	}
+	stats.Report(os.Stdout, *top)
+	return nil
+}
+
+// readStats adds the entries in the named log between from and until
+// (either of which may be zero) to stats.
+func readStats(stats *accesslog.Stats, file, format string, from, until time.Time) error {
+	f, err := accesslog.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := accesslog.NewReader(f, format)
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+		if !until.IsZero() && !e.Time.Before(until) {
+			continue
+		}
+		stats.Add(e)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxyproto implements the receiving side of the PROXY protocol
+// (versions 1 and 2), which TCP load balancers use to convey the address
+// of the original client.
+//
+// The protocol is described at:
+//   http://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the amount of time allowed for a PROXY header to arrive
+// if the Listener does not specify one.
+const DefaultTimeout = 5 * time.Second
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// A Listener accepts connections which may begin with a PROXY header.
+//
+// The header is read the first time the connection is read from or its
+// RemoteAddr or LocalAddr is requested, so that a slow client cannot
+// hold up the Accept loop.  If a header is present, the addresses it
+// contains are reported by the connection instead of the real ones.
+// Connections without a header are passed through unmodified.
+//
+// Since the header lets the peer choose the address the connection
+// reports, headers are only honored on connections from Trusted
+// networks.  Connections from anywhere else (including all connections,
+// if Trusted is empty) are passed through unmodified, so a header sent
+// by an untrusted peer is seen as part of its request.
+type Listener struct {
+	net.Listener
+
+	// Trusted lists the networks of the load balancers whose headers are honored.
+	Trusted []*net.IPNet
+
+	// Timeout limits how long to wait for the header.
+	Timeout time.Duration
+}
+
+// NewListener returns a Listener which honors PROXY headers from peers in
+// the trusted networks.
+func NewListener(l net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{Listener: l, Trusted: trusted}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return newConn(conn, timeout), nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Conn is a connection which may begin with a PROXY header.
+type Conn struct {
+	net.Conn
+
+	timeout time.Duration
+	once    sync.Once
+	r       *bufio.Reader
+	src     net.Addr
+	dst     net.Addr
+	err     error
+}
+
+func newConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		timeout: timeout,
+		r:       bufio.NewReader(conn),
+	}
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.dst, c.err = readHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read reads data from the connection, after any PROXY header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the source address from the PROXY header, if present.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the PROXY header, if present.
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads a PROXY header (if present) from r and returns
+// the addresses it contains.  The addresses will be nil if there was
+// no header or if it did not specify any (e.g. health checks).
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return nil, nil, err
+	}
+	if bytes.Equal(start, v1Prefix) {
+		return readV1(r)
+	}
+	if start[0] != v2Signature[0] {
+		return nil, nil, nil
+	}
+	if start, err = r.Peek(len(v2Signature)); err == nil && bytes.Equal(start, v2Signature) {
+		return readV2(r)
+	}
+	return nil, nil, nil
+}
+
+// readV1 reads a human-readable (version 1) header:
+//   PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	const maxLen = 107
+
+	var line []byte
+	for len(line) < maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxyproto: reading v1 header: %s", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("proxyproto: v1 header too long or unterminated")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+	switch fields[1] {
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unknown v1 protocol %q", fields[1])
+	}
+	if src, err = parseV1Addr(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = parseV1Addr(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("proxyproto: invalid v1 address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary (version 2) header.
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: reading v2 header: %s", err)
+	}
+	verCmd, family := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: reading v2 addresses: %s", err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported version %d", verCmd>>4)
+	}
+	switch verCmd & 0xF {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unsupported command %#x", verCmd&0xF)
+	}
+
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		// Unsupported families (UDP, unix) are treated like LOCAL
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("proxyproto: v2 address block too short (%d bytes)", len(body))
+	}
+	src = &net.TCPAddr{
+		IP:   net.IP(body[:size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(body[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return src, dst, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxyproto
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func v2(cmd, family byte, addrs ...byte) string {
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x20|cmd, family, 0, byte(len(addrs)))
+	return string(append(hdr, addrs...))
+}
+
+func TestConn(t *testing.T) {
+	tests := []struct {
+		desc   string
+		input  string
+		remote string // empty for the real address
+		local  string
+		body   string
+		err    bool
+	}{
+		{
+			desc:  "no header",
+			input: "GET / HTTP/1.0\r\n\r\n",
+			body:  "GET / HTTP/1.0\r\n\r\n",
+		},
+		{
+			desc:   "v1 tcp4",
+			input:  "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.0\r\n\r\n",
+			remote: "192.0.2.1:56324",
+			local:  "198.51.100.1:443",
+			body:   "GET / HTTP/1.0\r\n\r\n",
+		},
+		{
+			desc:   "v1 tcp6",
+			input:  "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nbody",
+			remote: "[2001:db8::1]:56324",
+			local:  "[2001:db8::2]:443",
+			body:   "body",
+		},
+		{
+			desc:  "v1 unknown",
+			input: "PROXY UNKNOWN\r\nbody",
+			body:  "body",
+		},
+		{
+			desc:  "v1 malformed",
+			input: "PROXY TCP4 192.0.2.1\r\nbody",
+			err:   true,
+		},
+		{
+			desc: "v2 tcp4",
+			input: v2(1, 0x11,
+				192, 0, 2, 1,
+				198, 51, 100, 1,
+				0xDC, 0x04,
+				0x01, 0xBB,
+			) + "body",
+			remote: "192.0.2.1:56324",
+			local:  "198.51.100.1:443",
+			body:   "body",
+		},
+		{
+			desc:  "v2 local",
+			input: v2(0, 0x00) + "body",
+			body:  "body",
+		},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+		go func() {
+			client.Write([]byte(test.input))
+			client.Close()
+		}()
+
+		conn := newConn(server, time.Second)
+		body, err := ioutil.ReadAll(conn)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: read succeeded, want error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: read: %s", test.desc, err)
+			continue
+		}
+		if got, want := string(body), test.body; got != want {
+			t.Errorf("%s: body = %q, want %q", test.desc, got, want)
+		}
+
+		remote, local := server.RemoteAddr().String(), server.LocalAddr().String()
+		if test.remote != "" {
+			remote, local = test.remote, test.local
+		}
+		if got, want := conn.RemoteAddr().String(), remote; got != want {
+			t.Errorf("%s: remote = %q, want %q", test.desc, got, want)
+		}
+		if got, want := conn.LocalAddr().String(), local; got != want {
+			t.Errorf("%s: local = %q, want %q", test.desc, got, want)
+		}
+	}
+}
+
+func TestListenerTrusted(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+	tests := []struct {
+		desc    string
+		trusted []*net.IPNet
+		honored bool
+	}{
+		{"no trusted networks", nil, false},
+		{"untrusted peer", []*net.IPNet{other}, false},
+		{"trusted peer", []*net.IPNet{other, loopback}, true},
+	}
+
+	for _, test := range tests {
+		raw, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %s", err)
+		}
+		l := NewListener(raw, test.trusted)
+
+		go func() {
+			c, err := net.Dial("tcp", raw.Addr().String())
+			if err != nil {
+				return
+			}
+			c.Write([]byte(header + "body"))
+			c.Close()
+		}()
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("%s: accept: %s", test.desc, err)
+		}
+		body, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Errorf("%s: read: %s", test.desc, err)
+		}
+		remote := conn.RemoteAddr().String()
+		conn.Close()
+		l.Close()
+
+		if test.honored {
+			if got, want := remote, "192.0.2.1:56324"; got != want {
+				t.Errorf("%s: remote = %q, want %q", test.desc, got, want)
+			}
+			if got, want := string(body), "body"; got != want {
+				t.Errorf("%s: body = %q, want %q", test.desc, got, want)
+			}
+			continue
+		}
+		if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+			t.Errorf("%s: remote = %q, want the real peer address", test.desc, remote)
+		}
+		if got, want := string(body), header+"body"; got != want {
+			t.Errorf("%s: body = %q, want the header passed through (%q)", test.desc, got, want)
+		}
+	}
+}
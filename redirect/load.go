@@ -0,0 +1,106 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redirect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Load reads a table of rules, one per line, in the following form:
+//   <kind> <from> <to> [<code>] [keep-query|drop-query]
+//
+// The kind is one of "exact", "prefix" or "regexp".  Blank lines and
+// lines beginning with # are ignored.  For example:
+//   # Old blog posts
+//   exact   /2009/07/welcome   /blog/2009/07/welcome
+//   prefix  /downloads/        /download/             302
+//   regexp  /(\d{4})/(\d{2})/(.*)   /blog/$1/$2/$3    308 drop-query
+func Load(r io.Reader) (*Table, error) {
+	t := NewTable()
+	lines := bufio.NewScanner(r)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err == nil {
+			err = t.Add(rule)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LoadFile reads a table of rules from the named file (see Load).
+func LoadFile(file string) (*Table, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return t, nil
+}
+
+func parseRule(line string) (Rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("%q is not in <kind> <from> <to> form", line)
+	}
+
+	var rule Rule
+	switch kind := fields[0]; kind {
+	case "exact":
+		rule.Kind = Exact
+	case "prefix":
+		rule.Kind = Prefix
+	case "regexp":
+		rule.Kind = Regexp
+	default:
+		return Rule{}, fmt.Errorf("unknown rule kind %q", kind)
+	}
+	rule.From, rule.To = fields[1], fields[2]
+
+	for _, opt := range fields[3:] {
+		switch opt {
+		case "keep-query":
+			rule.Query = KeepQuery
+		case "drop-query":
+			rule.Query = DropQuery
+		default:
+			code, err := strconv.Atoi(opt)
+			if err != nil {
+				return Rule{}, fmt.Errorf("unknown option %q", opt)
+			}
+			rule.Code = code
+		}
+	}
+	return rule, nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redirect implements a table of redirect rules, which can be used
+// to keep old URLs working when content moves.
+//
+// Rules match the path of a request in one of three ways:
+//   exact   - The path must be exactly the same
+//   prefix  - The path must be the same or be within the prefix directory;
+//             the prefix is replaced in the target
+//   regexp  - The path must match the (anchored) regular expression;
+//             $1, ${name}, etc in the target are replaced by the submatches
+//
+// Exact rules take precedence over regexp rules, which take precedence
+// over prefix rules.  Among regexp rules, the first one added wins, and
+// among prefix rules, the longest prefix wins.
+//
+// Lookups do not get significantly slower as more rules are added:
+// exact rules are found in a map, prefix rules are found by looking up
+// each directory of the path, and regexp rules are only tried if the
+// path begins with their literal prefix (so rules should avoid starting
+// with a wildcard when possible).
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Kind determines how a Rule matches a path.
+type Kind int
+
+const (
+	Exact Kind = iota
+	Prefix
+	Regexp
+)
+
+var kindNames = []string{
+	Exact:  "exact",
+	Prefix: "prefix",
+	Regexp: "regexp",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// A QueryMode determines what happens to the query of a redirected request.
+type QueryMode int
+
+const (
+	// KeepQuery adds the query from the request to the target
+	// (after any query in the target itself).  This is the default.
+	KeepQuery QueryMode = iota
+
+	// DropQuery discards the query from the request.
+	DropQuery
+)
+
+// DefaultCode is the status used for rules which do not specify one.
+const DefaultCode = http.StatusMovedPermanently
+
+// A Rule redirects requests for matching paths.
+type Rule struct {
+	Kind  Kind
+	From  string    // path, prefix or regular expression to match
+	To    string    // target path or URL
+	Code  int       // status code (DefaultCode if zero)
+	Query QueryMode // what to do with the query
+
+	index int            // order in which the rule was added
+	re    *regexp.Regexp // compiled From for Regexp rules
+}
+
+// A Table holds a set of redirect rules.  A Table must not be modified
+// while it is being used to serve requests.
+type Table struct {
+	count    int
+	exact    map[string]*Rule
+	prefix   map[string]*Rule
+	regexps  map[string][]*Rule // by literal prefix
+	literals []int              // distinct lengths of regexps keys, longest first
+}
+
+// NewTable returns an empty Table.
+func NewTable() *Table {
+	return &Table{
+		exact:   make(map[string]*Rule),
+		prefix:  make(map[string]*Rule),
+		regexps: make(map[string][]*Rule),
+	}
+}
+
+// Len returns the number of rules in the table.
+func (t *Table) Len() int {
+	return t.count
+}
+
+// Add adds a rule to the table.  An error is returned if the rule is invalid
+// or if it has the same path or prefix as an existing rule.
+func (t *Table) Add(rule Rule) error {
+	if rule.Code == 0 {
+		rule.Code = DefaultCode
+	}
+	if rule.Code < 300 || rule.Code > 399 {
+		return fmt.Errorf("%s %s: invalid redirect code %d", rule.Kind, rule.From, rule.Code)
+	}
+	rule.index = t.count
+
+	switch rule.Kind {
+	case Exact:
+		if _, exist := t.exact[rule.From]; exist {
+			return fmt.Errorf("exact %s: rule already exists", rule.From)
+		}
+		t.exact[rule.From] = &rule
+	case Prefix:
+		from := cleanPrefix(rule.From)
+		if _, exist := t.prefix[from]; exist {
+			return fmt.Errorf("prefix %s: rule already exists", rule.From)
+		}
+		t.prefix[from] = &rule
+	case Regexp:
+		expr := strings.TrimSuffix(strings.TrimPrefix(rule.From, "^"), "$")
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("regexp %s: %s", rule.From, err)
+		}
+		rule.re = re
+		literal, _ := re.LiteralPrefix()
+		t.regexps[literal] = append(t.regexps[literal], &rule)
+		t.addLiteral(len(literal))
+	default:
+		return fmt.Errorf("%s: unknown rule kind %d", rule.From, int(rule.Kind))
+	}
+	t.count++
+	return nil
+}
+
+// addLiteral records the length of a regexp literal prefix if it is new.
+func (t *Table) addLiteral(n int) {
+	for _, l := range t.literals {
+		if l == n {
+			return
+		}
+	}
+	t.literals = append(t.literals, n)
+	sort.Sort(sort.Reverse(sort.IntSlice(t.literals)))
+}
+
+// cleanPrefix removes the trailing slash from a prefix (other than "/").
+func cleanPrefix(prefix string) string {
+	if len(prefix) > 1 {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return prefix
+}
+
+// Match returns the rule which matches the given path, if any.
+func (t *Table) Match(path string) (*Rule, bool) {
+	if rule, ok := t.exact[path]; ok {
+		return rule, true
+	}
+
+	// Try the regexps whose literal prefix matches
+	var best *Rule
+	for _, n := range t.literals {
+		if n > len(path) {
+			continue
+		}
+		for _, rule := range t.regexps[path[:n]] {
+			if best != nil && best.index < rule.index {
+				break
+			}
+			if rule.re.MatchString(path) {
+				best = rule
+				break
+			}
+		}
+	}
+	if best != nil {
+		return best, true
+	}
+
+	// Try successively shorter prefixes
+	for prefix := path; prefix != ""; {
+		if rule, ok := t.prefix[prefix]; ok {
+			return rule, true
+		}
+		if prefix == "/" {
+			break
+		}
+		slash := strings.LastIndex(prefix, "/")
+		if slash <= 0 {
+			prefix = "/"
+			continue
+		}
+		prefix = prefix[:slash]
+	}
+	return nil, false
+}
+
+// Target returns the location to which the rule redirects the given URL,
+// which the rule must match.
+func (r *Rule) Target(u *urlpkg.URL) string {
+	var target string
+	switch r.Kind {
+	case Exact:
+		target = r.To
+	case Prefix:
+		rest := u.Path[len(cleanPrefix(r.From)):]
+		target = strings.TrimSuffix(r.To, "/") + "/" + strings.TrimPrefix(rest, "/")
+		if rest == "" {
+			target = r.To
+		}
+	case Regexp:
+		match := r.re.FindStringSubmatchIndex(u.Path)
+		target = string(r.re.ExpandString(nil, r.To, u.Path, match))
+	}
+
+	if r.Query == KeepQuery && u.RawQuery != "" {
+		if strings.Contains(target, "?") {
+			target += "&" + u.RawQuery
+		} else {
+			target += "?" + u.RawQuery
+		}
+	}
+	return target
+}
+
+// Lookup returns the location and status code with which the given URL
+// should be redirected, if any rule matches it.
+func (t *Table) Lookup(u *urlpkg.URL) (target string, code int, ok bool) {
+	rule, ok := t.Match(u.Path)
+	if !ok {
+		return "", 0, false
+	}
+	return rule.Target(u), rule.Code, true
+}
+
+// ServeHandler redirects the request if a rule matches it
+// and serves it with h otherwise.
+func (t *Table) ServeHandler(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	if target, code, ok := t.Lookup(r.URL); ok {
+		http.Redirect(w, r, target, code)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// ServeHTTP redirects the request if a rule matches it
+// and serves 404 Not Found otherwise.
+func (t *Table) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.ServeHandler(w, r, http.NotFoundHandler())
+}
+
+// Wrap returns a handler which redirects requests that match a rule
+// and serves all others with h.
+func (t *Table) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.ServeHandler(w, r, h)
+	})
+}
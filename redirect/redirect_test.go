@@ -0,0 +1,163 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rules = `
+# Blog posts moved under /blog
+exact   /2009/07/welcome          /blog/welcome
+regexp  /(\d{4})/(\d{2})/(.*)     /blog/$1/$2/$3   308
+regexp  /(?P<year>\d{4})/?        /blog/${year}/   drop-query
+regexp  /2010/.*                  /never
+prefix  /downloads/               /download/       302
+prefix  /downloads/old            https://archive.example.com/
+prefix  /                         /home            drop-query
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input string
+		err   string
+	}{
+		{
+			desc:  "valid",
+			input: rules,
+		},
+		{
+			desc:  "short",
+			input: "exact /foo",
+			err:   `line 1: "exact /foo" is not in <kind> <from> <to> form`,
+		},
+		{
+			desc:  "kind",
+			input: "\n# comment\nfuzzy /foo /bar",
+			err:   `line 3: unknown rule kind "fuzzy"`,
+		},
+		{
+			desc:  "option",
+			input: "exact /foo /bar keep-fragment",
+			err:   `line 1: unknown option "keep-fragment"`,
+		},
+		{
+			desc:  "code",
+			input: "exact /foo /bar 200",
+			err:   `line 1: exact /foo: invalid redirect code 200`,
+		},
+		{
+			desc:  "regexp",
+			input: "regexp /foo( /bar",
+			err:   "line 1: regexp /foo(: error parsing regexp: missing closing ): `^(?:/foo()$`",
+		},
+		{
+			desc:  "duplicate",
+			input: "prefix /foo /bar\nprefix /foo/ /baz",
+			err:   `line 2: prefix /foo/: rule already exists`,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Load(strings.NewReader(test.input))
+		if got, want := fmt.Sprint(err), test.err; test.err != "" && got != want {
+			t.Errorf("%s: Load: %s, want %s", test.desc, got, want)
+		}
+		if test.err == "" && err != nil {
+			t.Errorf("%s: Load: %s", test.desc, err)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table, err := Load(strings.NewReader(rules))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	tests := []struct {
+		url  string
+		code int
+		loc  string
+	}{
+		{"/2009/07/welcome?ref=x", 301, "/blog/welcome?ref=x"},
+		{"/2009/07/other-post", 308, "/blog/2009/07/other-post"},
+		{"/2010/07/first-wins", 308, "/blog/2010/07/first-wins"},
+		{"/2011?page=2", 301, "/blog/2011/"},
+		{"/downloads/gofr.tgz", 302, "/download/gofr.tgz"},
+		{"/downloads", 302, "/download/"},
+		{"/downloads/old/v1/gofr.tgz", 301, "https://archive.example.com/v1/gofr.tgz"},
+		{"/downloadsx?q=1", 301, "/home/downloadsx"},
+		{"/", 301, "/home"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatalf("NewRequest(%q): %s", test.url, err)
+		}
+		table.ServeHTTP(w, r)
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("GET %q: code = %d, want %d", test.url, got, want)
+		}
+		if got, want := w.HeaderMap.Get("Location"), test.loc; got != want {
+			t.Errorf("GET %q: Location = %q, want %q", test.url, got, want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	table := NewTable()
+	table.Add(Rule{Kind: Exact, From: "/old", To: "/new"})
+	h := table.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	}))
+
+	for _, test := range []struct {
+		url  string
+		code int
+	}{
+		{"/old", 301},
+		{"/new", 200},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("GET %q: code = %d, want %d", test.url, got, want)
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	table := NewTable()
+	for i := 0; i < 5000; i++ {
+		table.Add(Rule{Kind: Exact, From: fmt.Sprintf("/exact/%d", i), To: "/"})
+		table.Add(Rule{Kind: Prefix, From: fmt.Sprintf("/prefix/%d/", i), To: "/"})
+		table.Add(Rule{Kind: Regexp, From: fmt.Sprintf(`/re/%d/(\d+)`, i), To: "/$1"})
+	}
+	paths := []string{"/exact/4999", "/prefix/4999/a/b", "/re/4999/123", "/missing/path"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Match(paths[i%len(paths)])
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"kylelemons.net/go/gofr/accesslog"
+	"kylelemons.net/go/gofr/redirect"
+	"kylelemons.net/go/gofr/trie"
+)
+
+// replay implements the "replay" subcommand, which sends the requests
+// listed in urls.txt or an access log through the routes from setup
+// (and -redirects) and reports how each was handled:
+//   gofr replay [-format urls] [-previous old.txt] [-o new.txt] [urls.txt ...]
+// Backends are replaced with stubs, so nothing but the frontend itself
+// is exercised.  The results are written as trie.Expectations, so they
+// can be checked by TestRouting.  With -previous, only the requests whose
+// results have changed are listed, and the exit status is nonzero if
+// there are any.
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	format := fs.String("format", "", `Format of the inputs: "urls" or an access log format (default "urls" for .txt files, otherwise -access-format)`)
+	previous := fs.String("previous", "", "Results of a previous run to compare against")
+	output := fs.String("o", "", "File to which to write the results")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"urls.txt"}
+	}
+	var reqs []replayRequest
+	for _, file := range files {
+		f := *format
+		if f == "" {
+			f = *accessFormat
+			if strings.HasSuffix(file, ".txt") {
+				f = "urls"
+			}
+		}
+		r, err := readReplay(file, f)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, r...)
+	}
+
+	fe := setup()
+	if *redirects != "" {
+		var err error
+		if fe.Redirects, err = redirect.LoadFile(*redirects); err != nil {
+			return fmt.Errorf("redirects: %s", err)
+		}
+	}
+	stop, err := stubBackends(fe)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	results := make([]trie.Expectation, 0, len(reqs))
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		if key := req.Method + " " + req.URL; !seen[key] {
+			seen[key] = true
+			got, err := trie.Observe(fe, req.Method, req.URL)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", req.Method, req.URL, err)
+			}
+			results = append(results, got)
+		}
+	}
+
+	if *output != "" {
+		var buf strings.Builder
+		for _, r := range results {
+			fmt.Fprintln(&buf, r)
+		}
+		if err := ioutil.WriteFile(*output, []byte(buf.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	if *previous == "" {
+		if *output == "" {
+			for _, r := range results {
+				fmt.Println(r)
+			}
+		}
+		return nil
+	}
+
+	old, err := trie.LoadExpectations(*previous)
+	if err != nil {
+		return err
+	}
+	if n := diffReplay(os.Stdout, old, results); n > 0 {
+		return fmt.Errorf("%d of %d results differ from %s", n, len(results), *previous)
+	}
+	fmt.Printf("All %d results match %s\n", len(results), *previous)
+	return nil
+}
+
+// A replayRequest is a request to be replayed.
+type replayRequest struct {
+	Method, URL string
+}
+
+// readReplay reads the requests from a file, which is either a list of
+// requests in the form read by trie.LoadExpectations (format "urls"),
+// such as urls.txt, or an access log.
+func readReplay(file, format string) ([]replayRequest, error) {
+	if format == "urls" {
+		exps, err := trie.LoadExpectations(file)
+		if err != nil {
+			return nil, err
+		}
+		reqs := make([]replayRequest, len(exps))
+		for i, e := range exps {
+			reqs[i] = replayRequest{e.Method, e.URL}
+		}
+		return reqs, nil
+	}
+
+	f, err := accesslog.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := accesslog.NewReader(f, format)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []replayRequest
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return reqs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		reqs = append(reqs, replayRequest{e.Method, e.URL()})
+	}
+}
+
+// stubBackends points each backend of fe at a local server which
+// responds to every request with 200 OK.  The returned function stops
+// the servers.
+func stubBackends(fe *Frontend) (stop func(), err error) {
+	var listeners []net.Listener
+	stop = func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, be := range fe.Backends {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		name := be.Name
+		go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "stub backend %s: %s\n", name, r.URL)
+		}))
+
+		u := *be.URL
+		u.Scheme, u.Host = "http", l.Addr().String()
+		be.URL = &u
+	}
+	return stop, nil
+}
+
+// diffReplay writes the differences between two sets of results and
+// returns the number of requests whose results differ.  Requests which
+// were only replayed in one of the runs are listed but not counted.
+func diffReplay(w io.Writer, old, results []trie.Expectation) int {
+	key := func(r trie.Expectation) string { return r.Method + " " + r.URL }
+	before := make(map[string]trie.Expectation, len(old))
+	for _, r := range old {
+		r.Line = 0
+		before[key(r)] = r
+	}
+
+	changed := 0
+	for _, r := range results {
+		r.Line = 0
+		k := key(r)
+		prev, ok := before[k]
+		delete(before, k)
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "new:     %s\n", r)
+		case prev != r:
+			fmt.Fprintf(w, "changed: %s\n", prev)
+			fmt.Fprintf(w, "     to: %s\n", r)
+			changed++
+		}
+	}
+
+	var missing []string
+	for k := range before {
+		missing = append(missing, k)
+	}
+	sort.Strings(missing)
+	for _, k := range missing {
+		fmt.Fprintf(w, "missing: %s\n", before[k])
+	}
+	return changed
+}
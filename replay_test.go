@@ -0,0 +1,85 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"kylelemons.net/go/gofr/trie"
+)
+
+// TestRouting checks the routes from setup against the expectations in
+// urls.txt, with stub backends.
+func TestRouting(t *testing.T) {
+	exps, err := trie.LoadExpectations("urls.txt")
+	if err != nil {
+		t.Fatalf("load urls: %s", err)
+	}
+
+	fe := setup()
+	stop, err := stubBackends(fe)
+	if err != nil {
+		t.Fatalf("stubBackends: %s", err)
+	}
+	defer stop()
+
+	trie.CheckRoutes(t, fe, exps)
+	trie.CheckRoutes(t, fe, parse(t,
+		"GET /go/../browse/gofr -> 200 backend:gitweb",
+		"GET http://kylelemons.net/go/gofr?x=1 -> 200 backend:vanitypkg",
+	))
+}
+
+func parse(t *testing.T, lines ...string) (exps []trie.Expectation) {
+	for _, line := range lines {
+		e, err := trie.ParseExpectation(line)
+		if err != nil {
+			t.Fatalf("ParseExpectation(%q): %s", line, err)
+		}
+		exps = append(exps, e)
+	}
+	return exps
+}
+
+func TestDiffReplay(t *testing.T) {
+	old := parse(t,
+		"GET / -> 307 redirect:/ Location: /blog",
+		"GET /a -> 200 backend:blog",
+		"GET /b -> 200 backend:blog",
+	)
+	old[1].Line = 3
+	results := parse(t,
+		"GET / -> 307 redirect:/ Location: /blog",
+		"GET /a -> 404 <nil>",
+		"GET /c -> 200 backend:blog",
+	)
+
+	var buf bytes.Buffer
+	if got, want := diffReplay(&buf, old, results), 1; got != want {
+		t.Errorf("diffReplay = %d, want %d", got, want)
+	}
+	want := strings.Join([]string{
+		"changed: GET /a -> 200 backend:blog",
+		"     to: GET /a -> 404 <nil>",
+		"new:     GET /c -> 200 backend:blog",
+		"missing: GET /b -> 200 backend:blog",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"kylelemons.net/go/daemon"
+)
+
+// A JSONExporter writes each span as a line of JSON.  It is intended
+// for local testing; durations and offsets are in nanoseconds.
+type JSONExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewJSONExporter returns an exporter which writes spans to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// OpenJSONFile returns an exporter which appends spans to the named file.
+func OpenJSONFile(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONExporter(f), nil
+}
+
+// Export writes the span.
+func (e *JSONExporter) Export(s *Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(s); err != nil {
+		daemon.Warning.Printf("trace: exporting span %s: %s", s.SpanID, err)
+	}
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (e *JSONExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace implements W3C Trace Context propagation and simple spans.
+//
+// The trace context of a request is carried in two headers:
+//   traceparent - version, trace ID, parent span ID and flags, e.g.
+//                 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+//   tracestate  - vendor-specific data, which is passed along unmodified
+//
+// Spans record the timing of an operation within a trace and are
+// handed to an Exporter when they end.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header names
+const (
+	ParentHeader = "Traceparent"
+	StateHeader  = "Tracestate"
+)
+
+// FlagSampled is set in the flags of sampled traces.
+const FlagSampled = 0x01
+
+// A TraceID identifies a trace.
+type TraceID [16]byte
+
+// A SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsZero reports whether the ID is all zeros (which is invalid).
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+
+// IsZero reports whether the ID is all zeros (which is invalid).
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// MarshalText encodes the ID in hex.
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// MarshalText encodes the ID in hex.
+func (id SpanID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// A Context identifies a span and carries the trace information
+// which is propagated with it.
+type Context struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string // tracestate, passed along unmodified
+}
+
+// Sampled reports whether the trace is sampled.
+func (c Context) Sampled() bool {
+	return c.Flags&FlagSampled != 0
+}
+
+// Traceparent returns the traceparent header value for the context.
+func (c Context) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", c.TraceID, c.SpanID, c.Flags)
+}
+
+// ErrInvalid is returned when a traceparent header is malformed.
+var ErrInvalid = errors.New("invalid traceparent")
+
+// Parse parses a traceparent header value (and an optional tracestate).
+func Parse(traceparent, tracestate string) (Context, error) {
+	var c Context
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return c, ErrInvalid
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff {
+		return c, ErrInvalid
+	}
+	// Version 00 has exactly four fields; later versions may add more.
+	if version[0] == 0 && len(parts) != 4 {
+		return c, ErrInvalid
+	}
+	for _, field := range []struct {
+		dst []byte
+		src string
+	}{
+		{c.TraceID[:], parts[1]},
+		{c.SpanID[:], parts[2]},
+	} {
+		if len(field.src) != 2*len(field.dst) || strings.ToLower(field.src) != field.src {
+			return c, ErrInvalid
+		}
+		if _, err := hex.Decode(field.dst, []byte(field.src)); err != nil {
+			return c, ErrInvalid
+		}
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return c, ErrInvalid
+	}
+	if c.TraceID.IsZero() || c.SpanID.IsZero() {
+		return c, ErrInvalid
+	}
+	c.Flags = flags[0]
+	c.State = strings.TrimSpace(tracestate)
+	return c, nil
+}
+
+// FromHeader returns the trace context in the headers, if it is valid.
+func FromHeader(h http.Header) (Context, bool) {
+	c, err := Parse(h.Get(ParentHeader), strings.Join(h[StateHeader], ","))
+	return c, err == nil
+}
+
+// Inject sets the trace context headers in h.
+func (c Context) Inject(h http.Header) {
+	h.Set(ParentHeader, c.Traceparent())
+	if c.State != "" {
+		h.Set(StateHeader, c.State)
+	} else {
+		h.Del(StateHeader)
+	}
+}
+
+func random(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("trace: reading random ID: " + err.Error())
+	}
+}
+
+// NewTraceID returns a random trace ID.
+func NewTraceID() (id TraceID) {
+	for id.IsZero() {
+		random(id[:])
+	}
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func NewSpanID() (id SpanID) {
+	for id.IsZero() {
+		random(id[:])
+	}
+	return id
+}
+
+// An Event marks a point in time during a span.
+type Event struct {
+	Name   string        `json:"name"`
+	Offset time.Duration `json:"offset"` // since the start of the span
+}
+
+// A Span records the timing of an operation.
+//
+// The methods of a Span may be called concurrently (for example, from
+// net/http/httptrace hooks).  They may also be called on a nil *Span,
+// in which case they do nothing, so that callers need not check
+// whether tracing is enabled.
+type Span struct {
+	Name       string            `json:"name"`
+	Context    Context           `json:"-"`
+	TraceID    TraceID           `json:"trace_id"`
+	SpanID     SpanID            `json:"span_id"`
+	ParentID   *SpanID           `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration"`
+	Events     []Event           `json:"events,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	mu       sync.Mutex
+	exporter Exporter
+	ended    bool
+}
+
+// Start starts a span which is a child of the parent context (if it is valid)
+// or the root of a new sampled trace (otherwise).  When the span ends,
+// it is given to the exporter if the trace is sampled.
+func Start(name string, parent Context, exporter Exporter) *Span {
+	s := &Span{
+		Name:     name,
+		Start:    time.Now(),
+		exporter: exporter,
+	}
+	if parent.TraceID.IsZero() {
+		s.Context = Context{
+			TraceID: NewTraceID(),
+			Flags:   FlagSampled,
+		}
+	} else {
+		s.Context = parent
+		parentID := parent.SpanID
+		s.ParentID = &parentID
+	}
+	s.Context.SpanID = NewSpanID()
+	s.TraceID, s.SpanID = s.Context.TraceID, s.Context.SpanID
+	return s
+}
+
+// Event records that the named event happened now.
+func (s *Span) Event(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Events = append(s.Events, Event{name, time.Since(s.Start)})
+}
+
+// SetAttribute records a value describing the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// SetError records that the operation failed.
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// End records the duration of the span and exports it.  Only the first
+// call to End has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.Duration = time.Since(s.Start)
+	s.mu.Unlock()
+
+	if s.exporter != nil && s.Context.Sampled() {
+		s.exporter.Export(s)
+	}
+}
+
+// An Exporter receives spans when they end.  Export must not modify
+// the span and must be safe to call concurrently.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// ExporterFunc allows a function to be used as an Exporter.
+type ExporterFunc func(s *Span)
+
+// Export calls f(s).
+func (f ExporterFunc) Export(s *Span) { f(s) }
@@ -0,0 +1,161 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		parent string
+		ok     bool
+		flags  byte
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, 0x01},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, 0x00},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, 0x01},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, 0},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, 0},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, 0},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, 0},
+		{"garbage", false, 0},
+		{"", false, 0},
+	}
+
+	for _, test := range tests {
+		c, err := Parse(test.parent, "congo=t61rcWkgMzE")
+		if got, want := err == nil, test.ok; got != want {
+			t.Errorf("Parse(%q) error = %v, want ok=%v", test.parent, err, want)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if got, want := c.Flags, test.flags; got != want {
+			t.Errorf("Parse(%q).Flags = %#x, want %#x", test.parent, got, want)
+		}
+		if got, want := c.State, "congo=t61rcWkgMzE"; got != want {
+			t.Errorf("Parse(%q).State = %q, want %q", test.parent, got, want)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	in := http.Header{}
+	in.Set(ParentHeader, parent)
+	in.Add(StateHeader, "rojo=00f067aa0ba902b7")
+	in.Add(StateHeader, "congo=t61rcWkgMzE")
+	c, ok := FromHeader(in)
+	if !ok {
+		t.Fatalf("FromHeader(%v) failed", in)
+	}
+
+	out := http.Header{}
+	c.Inject(out)
+	if got, want := out.Get(ParentHeader), parent; got != want {
+		t.Errorf("traceparent = %q, want %q", got, want)
+	}
+	if got, want := out.Get(StateHeader), "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"; got != want {
+		t.Errorf("tracestate = %q, want %q", got, want)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	var exported []*Span
+	exporter := ExporterFunc(func(s *Span) { exported = append(exported, s) })
+
+	// Root span
+	root := Start("root", Context{}, exporter)
+	if root.ParentID != nil {
+		t.Errorf("root.ParentID = %v, want nil", root.ParentID)
+	}
+	if !root.Context.Sampled() {
+		t.Errorf("root is not sampled")
+	}
+
+	// Child span
+	child := Start("child", root.Context, exporter)
+	if got, want := child.TraceID, root.TraceID; got != want {
+		t.Errorf("child.TraceID = %s, want %s", got, want)
+	}
+	if child.ParentID == nil || *child.ParentID != root.SpanID {
+		t.Errorf("child.ParentID = %v, want %s", child.ParentID, root.SpanID)
+	}
+	if child.SpanID == root.SpanID {
+		t.Errorf("child.SpanID = %s, same as parent", child.SpanID)
+	}
+	child.Event("step")
+	child.SetError(errors.New("oops"))
+	child.End()
+	child.End()
+
+	// Unsampled span
+	unsampled := root.Context
+	unsampled.Flags = 0
+	Start("unsampled", unsampled, exporter).End()
+
+	if got, want := len(exported), 1; got != want {
+		t.Fatalf("exported %d spans, want %d", got, want)
+	}
+	if got, want := exported[0].Error, "oops"; got != want {
+		t.Errorf("exported error = %q, want %q", got, want)
+	}
+
+	// A nil span ignores everything
+	var span *Span
+	span.Event("ignored")
+	span.SetAttribute("ignored", "true")
+	span.End()
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewJSONExporter(&buf)
+
+	s := Start("test", Context{}, e)
+	s.SetAttribute("backend", "localhost:8080")
+	s.Event("first byte")
+	s.End()
+
+	var got struct {
+		Name       string
+		TraceID    string `json:"trace_id"`
+		SpanID     string `json:"span_id"`
+		Events     []Event
+		Attributes map[string]string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal %q: %s", buf.String(), err)
+	}
+	if got.Name != "test" || got.TraceID != s.TraceID.String() || got.SpanID != s.SpanID.String() {
+		t.Errorf("exported %q, want span %q trace %s span %s", buf.String(), "test", s.TraceID, s.SpanID)
+	}
+	if len(got.Events) != 1 || got.Events[0].Name != "first byte" {
+		t.Errorf("events = %+v, want [first byte]", got.Events)
+	}
+	if got, want := got.Attributes["backend"], "localhost:8080"; got != want {
+		t.Errorf("backend = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Clone returns a deep copy of the ServeMux (see Trie.Clone).
+func (s *ServeMux) Clone() *ServeMux {
+	clone := *s
+	clone.Trie = *s.Trie.Clone()
+	return &clone
+}
+
+// An AtomicMux is a ServeMux which can be modified while it is serving.
+//
+// Requests are served from an immutable snapshot of the ServeMux without
+// any locking.  Modifications are made to a copy of the current snapshot,
+// which replaces it once they are complete, so requests never observe
+// a partially applied change.
+type AtomicMux struct {
+	mu   sync.Mutex   // serializes updates
+	snap atomic.Value // *ServeMux
+}
+
+// NewAtomicMux returns an AtomicMux serving the given ServeMux, which
+// must not be modified afterward.  If mux is nil, an empty one is used.
+func NewAtomicMux(mux *ServeMux) *AtomicMux {
+	if mux == nil {
+		mux = NewServeMux()
+	}
+	mux.Trie.rebuild()
+	a := new(AtomicMux)
+	a.snap.Store(mux)
+	return a
+}
+
+// Snapshot returns the ServeMux currently being served.
+// It must not be modified.
+func (a *AtomicMux) Snapshot() *ServeMux {
+	return a.snap.Load().(*ServeMux)
+}
+
+// Store replaces the ServeMux being served.  It must not be modified afterward.
+// Any middleware which has not yet been applied is applied before it is served.
+func (a *AtomicMux) Store(mux *ServeMux) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mux.Trie.rebuild()
+	a.snap.Store(mux)
+}
+
+// Update calls f with a copy of the current ServeMux and serves the copy once
+// f returns.  If f returns an error or panics, the copy is discarded and the
+// current ServeMux continues to be served.  Updates are applied one at a time.
+func (a *AtomicMux) Update(f func(mux *ServeMux) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	mux := a.Snapshot().Clone()
+	if err := f(mux); err != nil {
+		return err
+	}
+	mux.Trie.rebuild()
+	a.snap.Store(mux)
+	return nil
+}
+
+// Handle registers a handler (see ServeMux.Handle).
+func (a *AtomicMux) Handle(pattern string, handler http.Handler) {
+	a.Update(func(mux *ServeMux) error {
+		mux.Handle(pattern, handler)
+		return nil
+	})
+}
+
+// HandleFunc registers a handler function (see ServeMux.HandleFunc).
+func (a *AtomicMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	a.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// Replace replaces a handler (see ServeMux.Replace).
+func (a *AtomicMux) Replace(pattern string, handler http.Handler) {
+	a.Update(func(mux *ServeMux) error {
+		mux.Replace(pattern, handler)
+		return nil
+	})
+}
+
+// Remove unregisters a handler (see ServeMux.Remove).
+func (a *AtomicMux) Remove(pattern string) error {
+	return a.Update(func(mux *ServeMux) error {
+		return mux.Remove(pattern)
+	})
+}
+
+// ServeHTTP serves the request using the current snapshot.
+func (a *AtomicMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Snapshot().ServeHTTP(w, r)
+}
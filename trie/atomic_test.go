@@ -0,0 +1,69 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAtomicMux(t *testing.T) {
+	mux := NewAtomicMux(nil)
+	mux.Handle("/foo", textHandler("one"))
+	mux.Handle("GET /api", textHandler("get"))
+
+	get := func(snap *ServeMux, url string) string {
+		w := httptest.NewRecorder()
+		snap.ServeHTTP(w, request(t, "GET", url))
+		return w.Body.String()
+	}
+
+	before := mux.Snapshot()
+	mux.Replace("/foo", textHandler("two"))
+	mux.Handle("POST /api", textHandler("post"))
+	if err := mux.Remove("/missing"); err == nil {
+		t.Errorf("Remove(%q) succeeded", "/missing")
+	}
+
+	// Failed and panicking updates are discarded
+	mux.Update(func(mux *ServeMux) error {
+		mux.Replace("/foo", textHandler("three"))
+		return errors.New("discard")
+	})
+	func() {
+		defer func() { recover() }()
+		mux.Handle("/foo", textHandler("dup"))
+	}()
+
+	if got, want := get(before, "/foo"), "one"; got != want {
+		t.Errorf("old snapshot: /foo = %q, want %q", got, want)
+	}
+	if got, want := get(mux.Snapshot(), "/foo"), "two"; got != want {
+		t.Errorf("new snapshot: /foo = %q, want %q", got, want)
+	}
+
+	// The old snapshot's Methods must not have been modified
+	w := httptest.NewRecorder()
+	before.ServeHTTP(w, request(t, "POST", "/api"))
+	if got, want := w.Code, 405; got != want {
+		t.Errorf("old snapshot: POST /api = %d, want %d", got, want)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, request(t, "POST", "/api"))
+	if got, want := w.Body.String(), "post"; got != want {
+		t.Errorf("new snapshot: POST /api = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A Trace records the decisions made while routing a request.
+type Trace struct {
+	Method string
+	URL    string
+
+	Steps   []string     // human-readable routing decisions, in order
+	Params  Params       // parameters captured while routing
+	Handler http.Handler // the handler which would serve the request
+}
+
+func (t *Trace) add(format string, args ...interface{}) {
+	t.Steps = append(t.Steps, fmt.Sprintf(format, args...))
+}
+
+// String returns the trace as a human-readable report.
+func (t *Trace) String() string {
+	lines := []string{fmt.Sprintf("%s %s", t.Method, t.URL)}
+	for i, step := range t.Steps {
+		lines = append(lines, fmt.Sprintf("%3d. %s", i+1, step))
+	}
+	lines = append(lines, "Handler: "+Describe(t.Handler))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Explain reports how a request with the given method and URL would be
+// routed, without serving it.  The URL should be absolute so that the
+// domain can be matched; if it is an https URL, the request is treated
+// as having been received over TLS.
+func (s *ServeMux) Explain(method, url string) (*Trace, error) {
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{}
+	}
+
+	trace := &Trace{
+		Method: method,
+		URL:    url,
+	}
+	h, r := s.lookup(r, trace)
+	if m, ok := h.(Methods); ok {
+		if mh, ok := m[method]; ok {
+			trace.add("method %s has a handler", method)
+			h = mh
+		} else if mh, ok := m["GET"]; ok && method == "HEAD" {
+			trace.add("method HEAD is served by the GET handler")
+			h = mh
+		} else if method == "OPTIONS" {
+			trace.add("method OPTIONS is answered with 204 No Content (Allow: %s)", m.Allow())
+		} else {
+			trace.add("method %s is not allowed (%s)", method, m.Allow())
+		}
+	}
+	trace.Handler = h
+	return trace, nil
+}
+
+// ServeExplain serves the Explain report for the method and url given in the
+// query parameters of the same names (the method defaults to GET).
+// It is suitable for use as a debug handler (see frontend.Frontend.Debug).
+func (s *ServeMux) ServeExplain(w http.ResponseWriter, r *http.Request) {
+	method, url := r.FormValue("method"), r.FormValue("url")
+	if method == "" {
+		method = "GET"
+	}
+	if url == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
+
+	trace, err := s.Explain(method, url)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	fmt.Fprint(w, trace)
+}
+
+// Explain reports how a request would be routed by the current snapshot
+// (see ServeMux.Explain).
+func (a *AtomicMux) Explain(method, url string) (*Trace, error) {
+	return a.Snapshot().Explain(method, url)
+}
+
+// ServeExplain serves an Explain report for the current snapshot
+// (see ServeMux.ServeExplain).
+func (a *AtomicMux) ServeExplain(w http.ResponseWriter, r *http.Request) {
+	a.Snapshot().ServeExplain(w, r)
+}
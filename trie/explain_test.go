@@ -0,0 +1,139 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func TestExplain(t *testing.T) {
+	mux := routesMux()
+	mux.Handle("example.com/blog/{slug}", textHandler("post"))
+
+	tests := []struct {
+		method string
+		url    string
+		want   string
+	}{
+		{
+			method: "GET",
+			url:    "http://Example.COM/blog/hello",
+			want: `GET http://Example.COM/blog/hello
+  1. host "Example.COM" normalized to "example.com" (port "")
+  2. domain matched "example.com" (2 of 2 labels)
+  3. path "/blog/hello" matched "/blog/hello" (2 of 2 pieces)
+  4. parameter "slug" = "hello"
+Handler: post
+`,
+		},
+		{
+			method: "POST",
+			url:    "http://www.example.com/blog/hello",
+			want: `POST http://www.example.com/blog/hello
+  1. host "www.example.com" normalized to "www.example.com" (port "")
+  2. domain matched "example.com" (2 of 3 labels)
+  3. partial domain match changes host to "example.com"
+Handler: redirect 302 to http://example.com/blog/hello
+`,
+		},
+		{
+			method: "GET",
+			url:    "/foo/../dir",
+			want: `GET /foo/../dir
+  1. host "" normalized to "" (port "")
+  2. domain matched "" (0 of 0 labels)
+  3. path "/foo/../dir" is not clean ("/dir")
+Handler: redirect 301 to /dir
+`,
+		},
+		{
+			method: "GET",
+			url:    "/dir",
+			want: `GET /dir
+  1. host "" normalized to "" (port "")
+  2. domain matched "" (0 of 0 labels)
+  3. path "/dir" matched "/dir" (1 of 1 pieces)
+  4. trailing slash policy "redirect" applies to "dir"
+Handler: redirect 302 to /dir/
+`,
+		},
+		{
+			method: "GET",
+			url:    "/foo/bar",
+			want: `GET /foo/bar
+  1. host "" normalized to "" (port "")
+  2. domain matched "" (0 of 0 labels)
+  3. path "/foo/bar" matched "/" (0 of 2 pieces)
+  4. partial match is not a directory
+Handler: net/http.NotFound
+`,
+		},
+		{
+			method: "OPTIONS",
+			url:    "/api/7",
+			want: `OPTIONS /api/7
+  1. host "" normalized to "" (port "")
+  2. domain matched "" (0 of 0 labels)
+  3. path "/api/7" matched "/api/7" (2 of 2 pieces)
+  4. parameter "id" = "7"
+  5. method OPTIONS is answered with 204 No Content (Allow: GET, HEAD, OPTIONS, PUT)
+Handler: methods GET, HEAD, OPTIONS, PUT
+`,
+		},
+		{
+			method: "DELETE",
+			url:    "/api/7",
+			want: `DELETE /api/7
+  1. host "" normalized to "" (port "")
+  2. domain matched "" (0 of 0 labels)
+  3. path "/api/7" matched "/api/7" (2 of 2 pieces)
+  4. parameter "id" = "7"
+  5. method DELETE is not allowed (GET, HEAD, OPTIONS, PUT)
+Handler: methods GET, HEAD, OPTIONS, PUT
+`,
+		},
+	}
+
+	for _, test := range tests {
+		trace, err := mux.Explain(test.method, test.url)
+		if err != nil {
+			t.Errorf("Explain(%q, %q): %s", test.method, test.url, err)
+			continue
+		}
+		if d := diff.Diff(trace.String(), test.want); d != "" {
+			t.Errorf("Explain(%q, %q):\n%s", test.method, test.url, d)
+		}
+	}
+
+	// The OPTIONS response is as explained
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, request(t, "OPTIONS", "/api/7"))
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Errorf("OPTIONS /api/7: code = %d, want %d", got, want)
+	}
+	if got, want := w.HeaderMap.Get("Allow"), "GET, HEAD, OPTIONS, PUT"; got != want {
+		t.Errorf("OPTIONS /api/7: Allow = %q, want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeExplain(w, request(t, "GET", "/__explain?method=DELETE&url=/api/7"))
+	if got, want := w.Body.String(), tests[len(tests)-1].want; got != want {
+		t.Errorf("ServeExplain:\n%s", diff.Diff(got, want))
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An Expectation describes how a request should be routed.  Expectations
+// are written one per line in the form
+//   METHOD URL -> [STATUS] [HANDLER] [Location: LOCATION]
+// for example
+//   GET http://example.com/blog/hello -> 200 post
+//   GET http://www.example.com/ -> 302 Location: http://example.com/
+// The handler is as reported by Describe.  Only the parts which are given
+// are checked; a line with no "->" expects any successful (non-error)
+// response.
+type Expectation struct {
+	Line     int // line number in the file, if any
+	Method   string
+	URL      string
+	Status   int    // 0 if unchecked
+	Handler  string // "" if unchecked
+	Location string // "" if unchecked
+}
+
+// String returns the expectation in the form it is parsed from.
+func (e Expectation) String() string {
+	s := e.Method + " " + e.URL
+	if e.Status == 0 && e.Handler == "" && e.Location == "" {
+		return s
+	}
+	s += " ->"
+	if e.Status != 0 {
+		s += " " + strconv.Itoa(e.Status)
+	}
+	if e.Handler != "" {
+		s += " " + e.Handler
+	}
+	if e.Location != "" {
+		s += " Location: " + e.Location
+	}
+	return s
+}
+
+// ParseExpectation parses a single expectation.
+func ParseExpectation(line string) (Expectation, error) {
+	var e Expectation
+	req, want := line, ""
+	if arrow := strings.Index(line, "->"); arrow >= 0 {
+		req, want = line[:arrow], strings.TrimSpace(line[arrow+2:])
+		if want == "" {
+			return e, fmt.Errorf("nothing expected after -> in %q", line)
+		}
+	}
+
+	fields := strings.Fields(req)
+	if len(fields) != 2 {
+		return e, fmt.Errorf("want METHOD URL, found %q", strings.TrimSpace(req))
+	}
+	e.Method, e.URL = fields[0], fields[1]
+
+	if loc := strings.Index(want, "Location:"); loc >= 0 {
+		want, e.Location = strings.TrimSpace(want[:loc]), strings.TrimSpace(want[loc+len("Location:"):])
+		if e.Location == "" {
+			return e, fmt.Errorf("empty Location in %q", line)
+		}
+	}
+	if fields := strings.Fields(want); len(fields) > 0 && len(fields[0]) == 3 {
+		if code, err := strconv.Atoi(fields[0]); err == nil {
+			e.Status = code
+			want = strings.TrimSpace(want[3:])
+		}
+	}
+	e.Handler = want
+	return e, nil
+}
+
+// ReadExpectations reads expectations, one per line.  Blank lines and
+// lines starting with # are ignored.
+func ReadExpectations(r io.Reader) ([]Expectation, error) {
+	var exps []Expectation
+	scan := bufio.NewScanner(r)
+	for line := 1; scan.Scan(); line++ {
+		text := strings.TrimSpace(scan.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		e, err := ParseExpectation(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		e.Line = line
+		exps = append(exps, e)
+	}
+	return exps, scan.Err()
+}
+
+// LoadExpectations reads expectations from the named file.
+func LoadExpectations(file string) ([]Expectation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	exps, err := ReadExpectations(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return exps, nil
+}
+
+// An Explainer can report how it would route a request.  ServeMux and
+// AtomicMux are Explainers.
+type Explainer interface {
+	Explain(method, url string) (*Trace, error)
+}
+
+// Observe serves a request with h and returns the status, handler and
+// location of the response as an Expectation.  The handler is only
+// reported if h is an Explainer.
+func Observe(h http.Handler, method, url string) (Expectation, error) {
+	got := Expectation{Method: method, URL: url}
+
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return got, err
+	}
+	r.RequestURI = r.URL.RequestURI()
+	r.RemoteAddr = "127.0.0.1:0"
+	if r.URL.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{}
+	}
+
+	if ex, ok := h.(Explainer); ok {
+		trace, err := ex.Explain(method, url)
+		if err != nil {
+			return got, err
+		}
+		got.Handler = Describe(trace.Handler)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	got.Status = rec.Code
+	got.Location = rec.Header().Get("Location")
+	return got, nil
+}
+
+// Check returns an error describing how got differs from e.
+func (e Expectation) Check(got Expectation) error {
+	var diffs []string
+	if e.Status == 0 && e.Handler == "" && e.Location == "" && got.Status >= 400 {
+		diffs = append(diffs, fmt.Sprintf("status %d, want success", got.Status))
+	}
+	if e.Status != 0 && got.Status != e.Status {
+		diffs = append(diffs, fmt.Sprintf("status %d, want %d", got.Status, e.Status))
+	}
+	if e.Handler != "" && got.Handler != e.Handler {
+		diffs = append(diffs, fmt.Sprintf("handler %q, want %q", got.Handler, e.Handler))
+	}
+	if e.Location != "" && got.Location != e.Location {
+		diffs = append(diffs, fmt.Sprintf("Location %q, want %q", got.Location, e.Location))
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %s", e.Method, e.URL, strings.Join(diffs, "; "))
+}
+
+// A Reporter receives errors from CheckRoutes.  It is satisfied by
+// *testing.T.
+type Reporter interface {
+	Errorf(format string, args ...interface{})
+}
+
+// CheckRoutes serves each expected request with h and reports each
+// expectation which is not met.  It is intended for use in tests with
+// expectations from LoadExpectations:
+//   exps, err := trie.LoadExpectations("testdata/routes.txt")
+//   if err != nil {
+//       t.Fatal(err)
+//   }
+//   trie.CheckRoutes(t, mux, exps)
+func CheckRoutes(t Reporter, h http.Handler, exps []Expectation) {
+	if helper, ok := t.(interface{ Helper() }); ok {
+		helper.Helper()
+	}
+	for _, e := range exps {
+		got, err := Observe(h, e.Method, e.URL)
+		if err == nil {
+			err = e.Check(got)
+		}
+		if err != nil {
+			if e.Line > 0 {
+				t.Errorf("line %d: %s", e.Line, err)
+			} else {
+				t.Errorf("%s", err)
+			}
+		}
+	}
+}
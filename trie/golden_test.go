@@ -0,0 +1,92 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseExpectation(t *testing.T) {
+	tests := []struct {
+		line string
+		want Expectation
+	}{
+		{"GET /foo", Expectation{Method: "GET", URL: "/foo"}},
+		{"GET /foo -> 200", Expectation{Method: "GET", URL: "/foo", Status: 200}},
+		{"GET /foo -> foo", Expectation{Method: "GET", URL: "/foo", Handler: "foo"}},
+		{"GET /foo -> 200 methods GET, PUT", Expectation{Method: "GET", URL: "/foo", Status: 200, Handler: "methods GET, PUT"}},
+		{"GET /dir -> 301 add trailing slash Location: /dir/", Expectation{Method: "GET", URL: "/dir", Status: 301, Handler: "add trailing slash", Location: "/dir/"}},
+		{"GET /dir -> Location: /dir/", Expectation{Method: "GET", URL: "/dir", Location: "/dir/"}},
+	}
+	for _, test := range tests {
+		got, err := ParseExpectation(test.line)
+		if err != nil {
+			t.Errorf("ParseExpectation(%q): %s", test.line, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseExpectation(%q) = %#v, want %#v", test.line, got, test.want)
+		}
+		if got := got.String(); got != test.line {
+			t.Errorf("String() = %q, want %q", got, test.line)
+		}
+	}
+
+	for _, line := range []string{"/foo", "GET /foo extra", "GET /foo ->", "GET /foo -> 200 Location:"} {
+		if _, err := ParseExpectation(line); err == nil {
+			t.Errorf("ParseExpectation(%q) succeeded, want error", line)
+		}
+	}
+}
+
+type reporter []string
+
+func (e *reporter) Errorf(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func TestCheckRoutes(t *testing.T) {
+	exps, err := ReadExpectations(strings.NewReader(`
+# Routes which work
+GET /foo
+GET /foo -> 200 foo
+PUT /api/7 -> 200 put
+GET /dir -> 302 redirect 302 to /dir/ Location: /dir/
+GET http://example.com/go/pkg -> 404 net/http.NotFound
+
+# Routes which do not
+GET /bar
+POST /api/7 -> 200
+GET /foo -> dir index
+GET /dir -> Location: /other/
+`))
+	if err != nil {
+		t.Fatalf("ReadExpectations: %s", err)
+	}
+
+	var got reporter
+	CheckRoutes(&got, routesMux(), exps)
+	want := []string{
+		`line 10: GET /bar: status 404, want success`,
+		`line 11: POST /api/7: status 405, want 200`,
+		`line 12: GET /foo: handler "foo", want "dir index"`,
+		`line 13: GET /dir: Location "/dir/", want "/other/"`,
+	}
+	if g, w := strings.Join(got, "\n"), strings.Join(want, "\n"); g != w {
+		t.Errorf("CheckRoutes reported:\n%s\nwant:\n%s", g, w)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler to add behavior to it, such as logging or
+// access control.  The Wrap methods of frontend.ACL, frontend.Auth and
+// frontend.HeaderPolicy can all be used as Middleware.
+//
+// Middleware attached to a ServeMux wraps each request it serves, in the
+// following order (outermost first):
+//   - global middleware (see ServeMux.Use)
+//   - domain middleware (see ServeMux.UseAt)
+//   - directory middleware, from the shallowest directory to the deepest
+//   - the handler itself
+//
+// Middleware attached at the same place is applied in the order in which
+// it was added, so the first one added sees the request first.  Each
+// Middleware is called once, when it is added, and not for every request.
+type Middleware func(http.Handler) http.Handler
+
+// A chain is the middleware of a trie, applied once to a link which
+// continues the request along its route.
+type chain struct {
+	handler http.Handler
+	size    int // number of middleware applied
+}
+
+// newChain applies the middleware to a link back to the new chain.
+func newChain(mw []Middleware) *chain {
+	c := &chain{size: len(mw)}
+	var h http.Handler = link{c}
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	c.handler = h
+	return c
+}
+
+// build applies any of the trie's middleware which has not yet been applied.
+func (t *Trie) build() {
+	applied := 0
+	for _, c := range t.built {
+		applied += c.size
+	}
+	if applied > len(t.Middleware) {
+		// The middleware was replaced
+		t.built, applied = nil, 0
+	}
+	if applied < len(t.Middleware) {
+		t.built = append(t.built[:len(t.built):len(t.built)], newChain(t.Middleware[applied:]))
+	}
+}
+
+// rebuild builds the tries at and below t, including those of domains.
+func (t *Trie) rebuild() {
+	t.build()
+	if d, ok := t.Leaf.(*Domain); ok {
+		d.Trie.rebuild()
+	}
+	for _, child := range t.Child {
+		child.rebuild()
+	}
+	for _, child := range t.Wild {
+		child.rebuild()
+	}
+}
+
+// A route is the chains through which a request is served, outermost
+// first, followed by the handler itself.
+type route struct {
+	chains  []*chain
+	handler http.Handler
+}
+
+type routeKey struct{}
+
+// ServeHTTP serves the request through the first chain of the route.
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), routeKey{}, rt)
+	rt.chains[0].handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// A link ends a chain by serving the next chain of the request's route or,
+// after the last one, the handler itself.
+type link struct {
+	chain *chain
+}
+
+func (l link) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The route is lost if middleware replaces the request's context with
+	// one which was not derived from it
+	if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+		for i, c := range rt.chains {
+			if c != l.chain {
+				continue
+			}
+			if i+1 < len(rt.chains) {
+				rt.chains[i+1].handler.ServeHTTP(w, r)
+			} else {
+				rt.handler.ServeHTTP(w, r)
+			}
+			return
+		}
+	}
+	http.Error(w, "trie: middleware did not pass on the context of the request", http.StatusInternalServerError)
+}
+
+// enclosing returns the chains of the directories which enclose the
+// request path (given as pieces), whether or not the request is routed
+// to a handler beneath them.  Literal pieces are preferred to parameters.
+func (t *Trie) enclosing(paths []string) (chains []*chain) {
+	named := func(name string) *Trie {
+		for _, child := range t.Child {
+			if child.Name == name {
+				return child
+			}
+		}
+		return nil
+	}
+	for i, piece := range paths {
+		// A request for a directory without its trailing slash is beneath it
+		last := i == len(paths)-1
+		next := named(piece)
+		if next == nil && last && !strings.HasSuffix(piece, "/") {
+			next = named(piece + "/")
+		}
+		for _, w := range t.Wild {
+			if next != nil {
+				break
+			}
+			if _, all := wildcard(w.Name); !all && (last || strings.HasSuffix(w.Name, "/") == strings.HasSuffix(piece, "/")) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		chains, t = next.chain(chains), next
+	}
+	return chains
+}
+
+// merge returns the chains followed by those of extra which it lacks.
+func merge(chains, extra []*chain) []*chain {
+next:
+	for _, e := range extra {
+		for _, c := range chains {
+			if c == e {
+				continue next
+			}
+		}
+		chains = append(chains[:len(chains):len(chains)], e)
+	}
+	return chains
+}
+
+// wrap returns a handler which serves h through the chains.  If trace is
+// non-nil, the middleware is only noted in the trace.
+func wrap(h http.Handler, chains []*chain, kind string, trace *Trace) http.Handler {
+	if trace != nil {
+		size := 0
+		for _, c := range chains {
+			size += c.size
+		}
+		if size > 0 {
+			trace.add("%d %s middleware apply", size, kind)
+		}
+		return h
+	}
+	if len(chains) == 0 {
+		return h
+	}
+	return &route{chains, h}
+}
+
+// Use adds middleware which wraps everything served by the ServeMux,
+// including redirects and responses for paths which are not found.
+func (s *ServeMux) Use(mw ...Middleware) {
+	s.Middleware = append(s.Middleware, mw...)
+	s.Trie.build()
+}
+
+// UseAt adds middleware to the domain or directory identified by the pattern
+// (see Handle).  The middleware wraps handlers registered for the pattern
+// and for all patterns beneath it, including ones registered later:
+//   example.com/       - everything served for example.com
+//   /api/              - /api/ and everything beneath it
+//
+// Requests for paths beneath a directory (or for the directory without its
+// trailing slash) are wrapped by its middleware even when they are served
+// by the handler of an enclosing directory.
+//
+// Middleware for a domain also wraps the redirects and not found responses
+// for that domain.  The pattern may not include a method.
+func (s *ServeMux) UseAt(pattern string, mw ...Middleware) {
+	if strings.Contains(pattern, " ") {
+		panic(fmt.Sprintf("middleware pattern %q may not have a method", pattern))
+	}
+	_, domain, path := parsePattern(pattern)
+
+	found := s.exact(domain)
+	if found == nil || found.Leaf == nil {
+		if _, err := s.Trie.Replace(domain, NewDomain()); err != nil {
+			panic(err)
+		}
+		found = s.exact(domain)
+	}
+
+	node := &found.Leaf.(*Domain).Trie
+	for i, piece := range path {
+		next, err := node.child(piece, i == len(path)-1)
+		if err != nil {
+			panic(err)
+		}
+		node = next
+	}
+	node.Middleware = append(node.Middleware, mw...)
+	node.build()
+}
+
+// Use adds global middleware (see ServeMux.Use).
+func (a *AtomicMux) Use(mw ...Middleware) {
+	a.Update(func(mux *ServeMux) error {
+		mux.Use(mw...)
+		return nil
+	})
+}
+
+// UseAt adds middleware for a domain or directory (see ServeMux.UseAt).
+func (a *AtomicMux) UseAt(pattern string, mw ...Middleware) {
+	a.Update(func(mux *ServeMux) error {
+		mux.UseAt(pattern, mw...)
+		return nil
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// tag returns middleware which appends name to the X-Chain response header.
+func tag(name string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := NewServeMux()
+	mux.Use(tag("global1"), tag("global2"))
+	mux.UseAt("/api/", tag("api"))
+	mux.Handle("/api/v1/items", textHandler("items"))
+	mux.UseAt("/api/v1/", tag("v1"))
+	mux.Handle("/api/{version}/other", textHandler("other"))
+	mux.Handle("/home", textHandler("home"))
+	mux.UseAt("example.com/", tag("example"))
+	mux.Handle("example.com/foo", textHandler("foo"))
+	mux.Handle("/a/", textHandler("a"))
+	mux.UseAt("/a/private/", tag("private"))
+
+	tests := []struct {
+		url   string
+		code  int
+		chain []string
+	}{
+		{"/api/v1/items", 200, []string{"global1", "global2", "api", "v1"}},
+		{"/api/v2/other", 200, []string{"global1", "global2", "api"}},
+		{"/api/v1/other", 200, []string{"global1", "global2", "api", "v1"}},
+		{"/a/x", 200, []string{"global1", "global2"}},
+		{"/a/private/x", 200, []string{"global1", "global2", "private"}},
+		{"/a/private/", 200, []string{"global1", "global2", "private"}},
+		{"/a/private", 200, []string{"global1", "global2", "private"}},
+		{"/a/privately", 200, []string{"global1", "global2"}},
+		{"/home", 200, []string{"global1", "global2"}},
+		{"/missing", 404, []string{"global1", "global2"}},
+		{"http://example.com/foo", 200, []string{"global1", "global2", "example"}},
+		{"http://example.com/missing", 404, []string{"global1", "global2", "example"}},
+		{"http://example.com/a/../foo", 301, []string{"global1", "global2", "example"}},
+		{"http://www.example.com/foo", 302, []string{"global1", "global2"}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, "GET", test.url))
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("GET %q: code = %d, want %d", test.url, got, want)
+		}
+		if got, want := w.HeaderMap["X-Chain"], test.chain; !equal(got, want) {
+			t.Errorf("GET %q: chain = %q, want %q", test.url, got, want)
+		}
+	}
+
+	trace, err := mux.Explain("GET", "/api/v1/items")
+	if err != nil {
+		t.Fatalf("Explain: %s", err)
+	}
+	if got, want := trace.Handler, http.Handler(textHandler("items")); got != want {
+		t.Errorf("Explain handler = %v, want %v", got, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMiddlewareBuiltOnce(t *testing.T) {
+	calls := map[string]int{}
+	counted := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			calls[name]++
+			return tag(name)(h)
+		}
+	}
+
+	mux := NewAtomicMux(nil)
+	mux.Use(counted("global"))
+	mux.UseAt("/api/", counted("api"))
+	mux.Handle("/api/items", textHandler("items"))
+	mux.Handle("/home", textHandler("home"))
+
+	serve := func(url string, chain ...string) {
+		t.Helper()
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, request(t, "GET", url))
+			if got := w.HeaderMap["X-Chain"]; !equal(got, chain) {
+				t.Errorf("GET %q: chain = %q, want %q", url, got, chain)
+			}
+		}
+	}
+	serve("/api/items", "global", "api")
+	serve("/home", "global")
+
+	mux.UseAt("/api/", counted("api2"))
+	serve("/api/items", "global", "api", "api2")
+
+	mux.Store(mux.Snapshot().Clone())
+	serve("/api/items", "global", "api", "api2")
+
+	if got, want := calls, map[string]int{"global": 1, "api": 1, "api2": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware calls = %v, want %v", got, want)
+	}
+}
+
+func TestMiddlewareNewContext(t *testing.T) {
+	mux := NewServeMux()
+	mux.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.Background()))
+		})
+	})
+	mux.Handle("/foo", textHandler("foo"))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, request(t, "GET", "/foo"))
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("code = %d, want %d", got, want)
+	}
+}
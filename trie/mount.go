@@ -0,0 +1,97 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type strippedKey struct{}
+
+// StrippedPrefix returns the path prefix which was stripped from the request
+// by mounts (see ServeMux.Mount), or "" if there was none.
+func StrippedPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(strippedKey{}).(string)
+	return prefix
+}
+
+// A mount is a handler which is served with the path it was matched by
+// replaced by another prefix.
+type mount struct {
+	replace string
+	http.Handler
+}
+
+func (m mount) String() string {
+	return fmt.Sprintf("mount at %q: %s", m.replace, Describe(m.Handler))
+}
+
+// Mount registers the given handler for the directory pattern (see Handle),
+// which must end in a slash.  The portion of the path matched by the pattern
+// is replaced by the replacement prefix (or "/" if it is empty) before the
+// request is served, so the handler need not know where it was mounted:
+//   mux.Mount("/blog/", "/", blog)  // /blog/2013/post is served as /2013/post
+//
+// The handler can retrieve the portion of the path which was replaced from
+// StrippedPrefix.  Mounts may be nested, in which case the stripped prefixes
+// accumulate.  The pattern may not include a method.
+func (s *ServeMux) Mount(pattern, replace string, handler http.Handler) {
+	if strings.Contains(pattern, " ") {
+		panic(fmt.Sprintf("mount pattern %q may not have a method", pattern))
+	}
+	if !strings.HasSuffix(pattern, "/") {
+		panic(fmt.Sprintf("mount pattern %q is not a directory", pattern))
+	}
+	s.Handle(pattern, mount{replace, handler})
+}
+
+// serve returns the request with which the leaf should be served after it
+// was matched by the given path pieces, stripping the matched path if the
+// leaf is a mount.
+func serve(leaf http.Handler, r *http.Request, matched []string, trace *Trace) (http.Handler, *http.Request) {
+	m, ok := leaf.(mount)
+	if !ok {
+		return leaf, r
+	}
+
+	prefix := strings.TrimSuffix("/"+strings.Join(matched, ""), "/")
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+
+	u := *r.URL
+	u.Path = strings.TrimSuffix(m.replace, "/") + rest
+	u.RawPath = ""
+	if trace != nil {
+		trace.add("mount strips %q, path becomes %q", prefix, u.Path)
+	}
+
+	ctx := context.WithValue(r.Context(), strippedKey{}, StrippedPrefix(r)+prefix)
+	r = r.WithContext(ctx)
+	r.URL = &u
+	return m.Handler, r
+}
+
+// Mount registers a mount (see ServeMux.Mount).
+func (a *AtomicMux) Mount(pattern, replace string, handler http.Handler) {
+	a.Update(func(mux *ServeMux) error {
+		mux.Mount(pattern, replace, handler)
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	show := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", StrippedPrefix(r), r.URL.Path, RequestParams(r).Get("user"))
+	})
+
+	inner := NewServeMux()
+	inner.Mount("/static/", "/files", show)
+
+	mux := NewServeMux()
+	mux.Policy.Slash = ServeBoth
+	mux.Mount("/blog/", "/", show)
+	mux.Mount("/go/", "/pkg/", show)
+	mux.Mount("/users/{user}/", "", inner)
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"/blog/2013/post", "/blog /2013/post "},
+		{"/blog/", "/blog / "},
+		{"/blog", "/blog / "},
+		{"/go/gofr/trie", "/go /pkg/gofr/trie "},
+		{"/go/sub/", "/go /pkg/sub/ "},
+		{"/users/bob/static/app.js", "/users/bob/static /files/app.js bob"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, "GET", test.url))
+		if got, want := w.Body.String(), test.body; got != want {
+			t.Errorf("GET %q: body = %q, want %q", test.url, got, want)
+		}
+	}
+
+	func() {
+		defer func() {
+			if got, want := fmt.Sprint(recover()), `mount pattern "/file" is not a directory`; got != want {
+				t.Errorf("Mount(%q): %q, want %q", "/file", got, want)
+			}
+		}()
+		mux.Mount("/file", "/", show)
+	}()
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"net/http"
+	"strings"
+)
+
+// A SlashPolicy determines how requests which differ from a registered
+// pattern only by a trailing slash are handled.
+type SlashPolicy int
+
+const (
+	// RedirectSlash redirects requests for a directory without its trailing
+	// slash to the directory.  Requests for a file with a trailing slash
+	// are not found.  This is the default.
+	RedirectSlash SlashPolicy = iota
+
+	// StripSlash redirects requests with a trailing slash to the same path
+	// without it, which serves the directory (or file) handler.
+	StripSlash
+
+	// ServeBoth serves requests with and without a trailing slash
+	// with the same handler and never redirects.
+	ServeBoth
+)
+
+var slashPolicyNames = []string{
+	RedirectSlash: "redirect",
+	StripSlash:    "strip",
+	ServeBoth:     "serve both",
+}
+
+func (p SlashPolicy) String() string {
+	if p < 0 || int(p) >= len(slashPolicyNames) {
+		return "unknown"
+	}
+	return slashPolicyNames[p]
+}
+
+// A Policy controls the redirects issued by a ServeMux or Domain.
+//
+// A status code of zero uses the default for that kind of redirect.
+// Use 307 Temporary Redirect or 308 Permanent Redirect to make clients
+// preserve the method and body of the request (e.g. for POST).
+type Policy struct {
+	HostCode  int // redirects to a registered domain (default 302)
+	CleanCode int // redirects to the cleaned path (default 301)
+	SlashCode int // redirects to add or strip a trailing slash (default 302)
+
+	Slash SlashPolicy
+}
+
+func (p *Policy) hostCode() int {
+	if p == nil || p.HostCode == 0 {
+		return http.StatusFound
+	}
+	return p.HostCode
+}
+
+func (p *Policy) cleanCode() int {
+	if p == nil || p.CleanCode == 0 {
+		return http.StatusMovedPermanently
+	}
+	return p.CleanCode
+}
+
+func (p *Policy) slashCode() int {
+	if p == nil || p.SlashCode == 0 {
+		return http.StatusFound
+	}
+	return p.SlashCode
+}
+
+func (p *Policy) slash() SlashPolicy {
+	if p == nil {
+		return RedirectSlash
+	}
+	return p.Slash
+}
+
+// HandlePolicy is like Handle, but the given policy is used for requests to
+// the pattern instead of the ServeMux's Policy.  The policy's HostCode and
+// CleanCode are ignored, since those redirects happen before a route is
+// chosen.
+//
+// A StripSlash or ServeBoth policy on a pattern without a trailing slash
+// also causes requests with a trailing slash to be redirected or served.
+func (s *ServeMux) HandlePolicy(pattern string, handler http.Handler, policy Policy) {
+	s.Handle(pattern, handler)
+
+	_, domain, path := parsePattern(pattern)
+	d := &s.exact(domain).Leaf.(*Domain).Trie
+	d.exact(path).Policy = &policy
+
+	if strings.HasSuffix(pattern, "/") {
+		if alias := d.exact(slashless(path)); alias != nil {
+			if isAlias(alias.Leaf) {
+				alias.Policy = &policy
+			}
+		}
+		return
+	}
+	if len(path) == 0 || policy.Slash == RedirectSlash {
+		return
+	}
+
+	// Register the trailing slash alias for the file
+	alias := append([]string(nil), path...)
+	alias[len(alias)-1] += "/"
+	if err := d.Insert(alias, slashRedirect{}); err == nil {
+		d.exact(alias).Policy = &policy
+	}
+}
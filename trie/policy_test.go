@@ -0,0 +1,164 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		desc   string
+		policy Policy
+		method string
+		url    string
+		code   int
+		redir  string
+		body   string
+	}{
+		{
+			desc:  "default slash",
+			url:   "/dir",
+			code:  302,
+			redir: "/dir/",
+		},
+		{
+			desc:   "slash code",
+			policy: Policy{SlashCode: 308},
+			method: "POST",
+			url:    "/dir",
+			code:   308,
+			redir:  "/dir/",
+		},
+		{
+			desc:   "host code",
+			policy: Policy{HostCode: 307},
+			url:    "http://www.example.com/foo",
+			code:   307,
+			redir:  "http://example.com/foo",
+		},
+		{
+			desc:   "clean code",
+			policy: Policy{CleanCode: 308},
+			url:    "/dir/../foo",
+			code:   308,
+			redir:  "/foo",
+		},
+		{
+			desc:   "strip directory",
+			policy: Policy{Slash: StripSlash},
+			url:    "/dir/",
+			code:   302,
+			redir:  "/dir",
+		},
+		{
+			desc:   "strip serves directory",
+			policy: Policy{Slash: StripSlash},
+			url:    "/dir",
+			code:   200,
+			body:   "dir",
+		},
+		{
+			desc:   "strip subtree",
+			policy: Policy{Slash: StripSlash},
+			url:    "/dir/sub",
+			code:   200,
+			body:   "dir",
+		},
+		{
+			desc:   "serve both",
+			policy: Policy{Slash: ServeBoth},
+			url:    "/dir",
+			code:   200,
+			body:   "dir",
+		},
+		{
+			desc:   "serve both index",
+			policy: Policy{Slash: ServeBoth},
+			url:    "/dir/",
+			code:   200,
+			body:   "dir",
+		},
+		{
+			desc:   "serve both with params",
+			policy: Policy{Slash: ServeBoth},
+			url:    "/users/bob",
+			code:   200,
+			body:   "user bob",
+		},
+		{
+			desc:  "per-route strip file",
+			url:   "/api/items/",
+			code:  307,
+			redir: "/api/items",
+		},
+		{
+			desc: "per-route strip file subtree",
+			url:  "/api/items/x",
+			code: 404,
+		},
+		{
+			desc: "per-route serve both",
+			url:  "/both",
+			code: 200,
+			body: "both",
+		},
+		{
+			desc: "per-route serve both file",
+			url:  "/file/",
+			code: 200,
+			body: "file",
+		},
+		{
+			desc: "file with slash",
+			url:  "/foo/",
+			code: 404,
+		},
+	}
+
+	for _, test := range tests {
+		mux := NewServeMux()
+		mux.Policy = test.policy
+		mux.Handle("example.com/foo", textHandler("foo"))
+		mux.Handle("/foo", textHandler("foo"))
+		mux.Handle("/dir/", textHandler("dir"))
+		mux.HandleFunc("/users/{name}/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("user " + RequestParams(r).Get("name")))
+		})
+		mux.HandlePolicy("/api/items", textHandler("items"), Policy{Slash: StripSlash, SlashCode: 307})
+		mux.HandlePolicy("/both/", textHandler("both"), Policy{Slash: ServeBoth})
+		mux.HandlePolicy("/file", textHandler("file"), Policy{Slash: ServeBoth})
+
+		method := test.method
+		if method == "" {
+			method = "GET"
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, method, test.url))
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("%s: %s %q: code = %d, want %d", test.desc, method, test.url, got, want)
+		}
+		if got, want := w.HeaderMap.Get("Location"), test.redir; got != want {
+			t.Errorf("%s: %s %q: Location = %q, want %q", test.desc, method, test.url, got, want)
+		}
+		if test.body != "" {
+			if got, want := w.Body.String(), test.body; got != want {
+				t.Errorf("%s: %s %q: body = %q, want %q", test.desc, method, test.url, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// SkipChildren can be returned from a WalkFunc to skip the children of a Trie.
+var SkipChildren = errors.New("skip children")
+
+// A WalkFunc is called for each Trie visited by Walk.  The paths to the
+// Trie are only valid for the duration of the call.  If it returns an error
+// (other than SkipChildren), the walk is stopped and the error is returned.
+type WalkFunc func(paths []string, t *Trie) error
+
+// Walk calls fn for the trie and each of its children (depth first, with
+// literal children in order followed by wildcards in order of precedence).
+func (t *Trie) Walk(fn WalkFunc) error {
+	return t.walk(nil, fn)
+}
+
+func (t *Trie) walk(paths []string, fn WalkFunc) error {
+	if err := fn(paths, t); err == SkipChildren {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, children := range [][]*Trie{t.Child, t.Wild} {
+		for _, child := range children {
+			if err := child.walk(append(paths, child.Name), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A Route describes a handler registered with a ServeMux.
+type Route struct {
+	Pattern string       // pattern as it would be passed to Handle
+	Handler http.Handler // handler (for a single method, if applicable)
+}
+
+// String returns the pattern and a description of the handler.
+func (r Route) String() string {
+	return fmt.Sprintf("%s %s", r.Pattern, Describe(r.Handler))
+}
+
+// Describe returns a short description of a handler.  Handlers which
+// implement fmt.Stringer describe themselves; functions are described
+// by name and other handlers by type.
+func Describe(h http.Handler) string {
+	switch h := h.(type) {
+	case nil:
+		return "<nil>"
+	case fmt.Stringer:
+		return h.String()
+	case http.HandlerFunc:
+		if fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); fn != nil {
+			return fn.Name()
+		}
+	case Methods:
+		return "methods " + h.Allow()
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// domainName returns the domain (with port, if any) for the given
+// reversed labels as they would appear in a pattern.
+func domainName(labels []string) string {
+	var port string
+	if len(labels) > 0 && strings.HasPrefix(labels[0], ":") {
+		port, labels = labels[0][1:], labels[1:]
+	}
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		if label == "{*}" {
+			label = "*"
+		}
+		names[len(labels)-1-i] = label
+	}
+	host := strings.Join(names, ".")
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return host
+}
+
+// domains calls fn with the name and Domain for each domain
+// registered in the ServeMux.
+func (s *ServeMux) domains(fn func(name string, d *Domain)) {
+	s.Walk(func(labels []string, t *Trie) error {
+		if d, ok := t.Leaf.(*Domain); ok {
+			fn(domainName(labels), d)
+		}
+		return nil
+	})
+}
+
+// Routes returns the handlers registered with the ServeMux, ordered by
+// domain and then by path.  Handlers registered for specific methods are
+// listed individually.
+func (s *ServeMux) Routes() []Route {
+	var routes []Route
+	s.domains(func(name string, d *Domain) {
+		d.Walk(func(paths []string, t *Trie) error {
+			if t.Leaf == nil || (len(paths) == 0 && isNotFound(t.Leaf)) {
+				return nil
+			}
+			pattern := name + "/" + strings.Join(paths, "")
+			m, ok := t.Leaf.(Methods)
+			if !ok {
+				routes = append(routes, Route{pattern, t.Leaf})
+				return nil
+			}
+			methods := make([]string, 0, len(m))
+			for method := range m {
+				methods = append(methods, method)
+			}
+			sort.Strings(methods)
+			for _, method := range methods {
+				routes = append(routes, Route{method + " " + pattern, m[method]})
+			}
+			return nil
+		})
+	})
+	return routes
+}
+
+// ListRoutes serves the routing tree of the ServeMux as plain text.
+// It is suitable for use as a debug handler (see frontend.Frontend.Debug).
+func (s *ServeMux) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+
+	s.domains(func(name string, d *Domain) {
+		if name == "" {
+			name = "*"
+		}
+		fmt.Fprintf(w, "Domain %q:\n", name)
+
+		// Collect the tree so that the descriptions can be aligned
+		var nodes, descs []string
+		width := 0
+		d.Walk(func(paths []string, t *Trie) error {
+			node, desc := strings.Repeat("  ", len(paths))+t.Name, ""
+			if len(paths) == 0 {
+				node = "/"
+			}
+			if t.Leaf != nil {
+				desc = Describe(t.Leaf)
+				if len(node) > width {
+					width = len(node)
+				}
+			}
+			nodes, descs = append(nodes, node), append(descs, desc)
+			return nil
+		})
+		for i, node := range nodes {
+			if descs[i] == "" {
+				fmt.Fprintf(w, "  %s\n", node)
+				continue
+			}
+			fmt.Fprintf(w, "  %-*s  %s\n", width, node, descs[i])
+		}
+	})
+}
+
+// Routes returns the handlers in the current snapshot (see ServeMux.Routes).
+func (a *AtomicMux) Routes() []Route {
+	return a.Snapshot().Routes()
+}
+
+// ListRoutes serves the routing tree of the current snapshot (see ServeMux.ListRoutes).
+func (a *AtomicMux) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	a.Snapshot().ListRoutes(w, r)
+}
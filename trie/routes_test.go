@@ -0,0 +1,86 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trie
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func (s textHandler) String() string { return string(s) }
+
+func routesMux() *ServeMux {
+	mux := NewServeMux()
+	mux.Handle("/foo", textHandler("foo"))
+	mux.Handle("/dir/", textHandler("dir index"))
+	mux.Handle("GET /api/{id}", textHandler("get"))
+	mux.Handle("PUT /api/{id}", textHandler("put"))
+	mux.Handle("*.example.com:8080/x", textHandler("x"))
+	mux.HandleFunc("example.com/go/...", http.NotFound)
+	return mux
+}
+
+func TestRoutes(t *testing.T) {
+	var got []string
+	for _, r := range routesMux().Routes() {
+		got = append(got, r.String())
+	}
+	want := []string{
+		"GET /api/{id} get",
+		"PUT /api/{id} put",
+		"/dir add trailing slash",
+		"/dir/ dir index",
+		"/foo foo",
+		"*.example.com:8080/x x",
+		"example.com/go/... net/http.NotFound",
+	}
+	if d := diff.Diff(join(got), join(want)); d != "" {
+		t.Errorf("Routes():\n%s", d)
+	}
+}
+
+func TestListRoutes(t *testing.T) {
+	w := httptest.NewRecorder()
+	routesMux().ListRoutes(w, request(t, "GET", "/__routes"))
+	want := `Domain "*":
+  /         net/http.NotFound
+    api/
+      {id}  methods GET, HEAD, OPTIONS, PUT
+    dir     add trailing slash
+    dir/    dir index
+    foo     foo
+Domain "*.example.com:8080":
+  /    net/http.NotFound
+    x  x
+Domain "example.com":
+  /        net/http.NotFound
+    go/
+      ...  net/http.NotFound
+`
+	if d := diff.Diff(w.Body.String(), want); d != "" {
+		t.Errorf("ListRoutes:\n%s", d)
+	}
+}
+
+func join(lines []string) string {
+	var s string
+	for _, line := range lines {
+		s += line + "\n"
+	}
+	return s
+}
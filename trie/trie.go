@@ -17,12 +17,18 @@
 package trie
 
 import (
+	"context"
+	"net"
 	"net/http"
 	pathpkg "path"
+	"reflect"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"fmt"
+
+	"golang.org/x/net/idna"
 )
 
 func reverse(s []string) {
@@ -32,6 +38,41 @@ func reverse(s []string) {
 	}
 }
 
+// hostLabels normalizes the host portion of a request or pattern and
+// returns its labels in reverse order along with the port (if any).
+//
+// Normalization lowercases the host, removes any trailing dot, and converts
+// internationalized labels to their ASCII (punycode) form.  IPv6 literals
+// are returned as a single label without their brackets.
+func hostLabels(host string) (labels []string, port string) {
+	switch {
+	case strings.HasPrefix(host, "["):
+		if end := strings.Index(host, "]"); end > 0 {
+			host, port = host[1:end], strings.TrimPrefix(host[end+1:], ":")
+			return []string{strings.ToLower(host)}, port
+		}
+	case strings.Count(host, ":") == 1:
+		colon := strings.Index(host, ":")
+		host, port = host[:colon], host[colon+1:]
+	case strings.Contains(host, ":"):
+		return []string{strings.ToLower(host)}, ""
+	}
+
+	labels = vaccuum(strings.Split(strings.ToLower(host), "."))
+	for i, label := range labels {
+		for _, r := range label {
+			if r >= utf8.RuneSelf {
+				if ascii, err := idna.Lookup.ToASCII(label); err == nil {
+					labels[i] = ascii
+				}
+				break
+			}
+		}
+	}
+	reverse(labels)
+	return labels, port
+}
+
 func vaccuum(s []string) []string {
 	for len(s) > 0 && s[0] == "" {
 		s = s[1:]
@@ -44,10 +85,26 @@ func vaccuum(s []string) []string {
 
 // A Trie can store a prefix tree of paths or a suffix tree of domains.
 // It is the basis for the Domain and ServeMux type.
+//
+// In addition to literal pieces, a Trie may contain wildcard pieces:
+//   {name}     - Matches any single piece, which is captured as a Param
+//   {name}/    - Like {name}, but for a directory piece
+//   {name...}  - Matches all remaining pieces (must be the final piece)
+//   ...        - Like {name...}, but captured with the name "..."
+//
+// When finding a match, literal pieces take precedence over parameters,
+// which take precedence over catch-alls.  A wildcard is only tried if the
+// more specific alternatives fail to match.
 type Trie struct {
-	Name  string       // path piece
-	Child []*Trie      // child tries
-	Leaf  http.Handler // handler for this file/dir or nil to use parent
+	Name   string       // path piece
+	Child  []*Trie      // child tries
+	Wild   []*Trie      // wildcard child tries (parameters before catch-alls)
+	Leaf   http.Handler // handler for this file/dir or nil to use parent
+	Policy *Policy      // redirect policy for this file/dir or nil to use default
+
+	// Middleware wraps the handlers at and below this file/dir (see Use).
+	Middleware []Middleware
+	built      []*chain // Middleware as applied by build
 }
 
 type byName []*Trie
@@ -56,12 +113,104 @@ func (v byName) Len() int           { return len(v) }
 func (v byName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 func (v byName) Less(i, j int) bool { return v[i].Name < v[j].Name }
 
+type byWildcard []*Trie
+
+func (v byWildcard) Len() int      { return len(v) }
+func (v byWildcard) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byWildcard) Less(i, j int) bool {
+	_, ci := wildcard(v[i].Name)
+	_, cj := wildcard(v[j].Name)
+	if ci != cj {
+		return !ci
+	}
+	return v[i].Name < v[j].Name
+}
+
+// wildcard returns the parameter name of the given piece if it is a wildcard
+// and whether it is a catch-all.  If it is not a wildcard, name is "".
+func wildcard(piece string) (name string, catchAll bool) {
+	if piece == "..." {
+		return "...", true
+	}
+	p := strings.TrimSuffix(piece, "/")
+	if len(p) < 3 || p[0] != '{' || p[len(p)-1] != '}' {
+		return "", false
+	}
+	name = p[1 : len(p)-1]
+	if strings.HasSuffix(name, "...") {
+		return strings.TrimSuffix(name, "..."), true
+	}
+	return name, false
+}
+
+// A Param is a value captured by a wildcard piece.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Params holds the values captured while matching a request.
+type Params []Param
+
+// Get returns the value of the named parameter, or "" if there is none.
+func (p Params) Get(name string) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].Name == name {
+			return p[i].Value
+		}
+	}
+	return ""
+}
+
+type paramsKey struct{}
+
+// RequestParams returns the parameters captured when routing the request.
+func RequestParams(r *http.Request) Params {
+	p, _ := r.Context().Value(paramsKey{}).(Params)
+	return p
+}
+
+// withParams returns a copy of r with the given parameters
+// added to any which are already present.
+func withParams(r *http.Request, p Params) *http.Request {
+	if len(p) == 0 {
+		return r
+	}
+	if prev := RequestParams(r); len(prev) > 0 {
+		p = append(append(Params{}, prev...), p...)
+	}
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, p))
+}
+
 // Find attempts to find the deepest matching child of this Trie with a non-nil
 // Leaf and return the number of path segments required to reach it and the
 // Trie present at that location.
 func (t *Trie) Find(paths []string) (int, *Trie) {
+	n, found, _ := t.Match(paths)
+	return n, found
+}
+
+// Match is like Find, but it also returns the values of any parameters
+// which were matched along the way.
+func (t *Trie) Match(paths []string) (int, *Trie, Params) {
+	n, found, params, _ := t.match(paths, nil, nil)
+	return n, found, params
+}
+
+// match is like Match, but it also returns the middleware chains of the tries
+// (below t) on the way to the match, outermost first.
+func (t *Trie) match(paths []string, params Params, chains []*chain) (int, *Trie, Params, []*chain) {
 	if len(paths) == 0 {
-		return 0, t
+		if t.Leaf == nil || isNotFound(t.Leaf) {
+			// A catch-all may match nothing at all (the NotFound
+			// placeholder at the root of a Domain does not count)
+			for _, w := range t.Wild {
+				if name, all := wildcard(w.Name); all && w.Leaf != nil {
+					return 0, w, append(params, Param{name, ""}), w.chain(chains)
+				}
+			}
+		}
+		return 0, t, params, chains
 	}
 
 	search, piece := t.Child, paths[0]
@@ -69,9 +218,9 @@ func (t *Trie) Find(paths []string) (int, *Trie) {
 		i := len(search) / 2
 		cur := search[i]
 		if piece == cur.Name {
-			n, found := cur.Find(paths[1:])
-			if found.Leaf != nil {
-				return n + 1, found
+			n, found, p, c := cur.match(paths[1:], params, cur.chain(chains))
+			if found.Leaf != nil && (n+1 == len(paths) || !isAlias(found.Leaf)) {
+				return n + 1, found, p, c
 			}
 			break
 		} else if piece < cur.Name {
@@ -80,58 +229,323 @@ func (t *Trie) Find(paths []string) (int, *Trie) {
 			search = search[i+1:]
 		}
 	}
-	return 0, t
+
+	for _, w := range t.Wild {
+		name, all := wildcard(w.Name)
+		if all {
+			if w.Leaf != nil {
+				return len(paths), w, append(params, Param{name, strings.Join(paths, "")}), w.chain(chains)
+			}
+			continue
+		}
+		if strings.HasSuffix(w.Name, "/") != strings.HasSuffix(piece, "/") {
+			continue
+		}
+		n, found, p, c := w.match(paths[1:], append(params, Param{name, strings.TrimSuffix(piece, "/")}), w.chain(chains))
+		if found.Leaf != nil && (n+1 == len(paths) || !isAlias(found.Leaf)) {
+			return n + 1, found, p, c
+		}
+	}
+	return 0, t, params, chains
 }
 
-// Insert inserts the given handler in the trie at the given path and returns
-// an error if it could not be inserted (usually because it already existed).
-func (t *Trie) Insert(paths []string, leaf http.Handler) error {
-	if len(paths) == 0 {
-		if t.Leaf != nil {
-			return fmt.Errorf("%s: leaf already exists", t.Name)
+// chain returns the given chains followed by the trie's own.
+func (t *Trie) chain(chains []*chain) []*chain {
+	if len(t.built) == 0 {
+		return chains
+	}
+	return append(chains[:len(chains):len(chains)], t.built...)
+}
+
+// isAlias returns true if the leaf is a trailing slash alias, which
+// is only matched by its exact path.
+func isAlias(leaf http.Handler) bool {
+	_, ok := leaf.(slashRedirect)
+	return ok
+}
+
+// exact returns the child of this Trie at exactly the given path
+// (without considering wildcards) or nil if there is none.
+func (t *Trie) exact(paths []string) *Trie {
+	find := func(children []*Trie, name string) *Trie {
+		for _, child := range children {
+			if child.Name == name {
+				return child
+			}
 		}
-		t.Leaf = leaf
 		return nil
 	}
+	for _, next := range paths {
+		found := find(t.Child, next)
+		if found == nil {
+			found = find(t.Wild, next)
+		}
+		if found == nil {
+			return nil
+		}
+		t = found
+	}
+	return t
+}
 
-	next := paths[0]
-
+// child returns the child of this Trie with the given name, creating it if
+// necessary, or an error if the name is a wildcard which conflicts with an
+// existing one.
+func (t *Trie) child(next string, last bool) (*Trie, error) {
 	// for the insert case, we don't really care as much about efficiency,
 	// so we won't use a binary search for now.
 	var found *Trie
+	if name, all := wildcard(next); name != "" {
+		if all && (!last || strings.HasSuffix(next, "/")) {
+			return nil, fmt.Errorf("%s: catch-all must be the final piece", next)
+		}
+		for _, child := range t.Wild {
+			if child.Name == next {
+				return child, nil
+			}
+			// Wildcards of the same kind would be ambiguous
+			other, otherAll := wildcard(child.Name)
+			sameDir := strings.HasSuffix(child.Name, "/") == strings.HasSuffix(next, "/")
+			if all == otherAll && (all || sameDir) && name != other {
+				return nil, fmt.Errorf("%s: conflicts with %s", next, child.Name)
+			}
+		}
+
+		found = &Trie{
+			Name: next,
+		}
+		t.Wild = append(t.Wild, found)
+		sort.Sort(byWildcard(t.Wild))
+		return found, nil
+	}
+
 	for _, child := range t.Child {
 		if child.Name == next {
-			found = child
-			break
+			return child, nil
 		}
 	}
 
 	// Create the node if it wasn't found
-	if found == nil {
-		found = &Trie{
-			Name: next,
+	found = &Trie{
+		Name: next,
+	}
+	t.Child = append(t.Child, found)
+	sort.Sort(byName(t.Child))
+	return found, nil
+}
+
+// wrap prefixes err with the name of this Trie (if it has one).
+func (t *Trie) wrap(err error) error {
+	if t.Name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", t.Name, err)
+}
+
+// Insert inserts the given handler in the trie at the given path and returns
+// an error if it could not be inserted (usually because it already existed).
+func (t *Trie) Insert(paths []string, leaf http.Handler) error {
+	if len(paths) == 0 {
+		if add, ok := leaf.(Methods); ok {
+			if existing, ok := t.Leaf.(Methods); ok {
+				for method := range add {
+					if _, exist := existing[method]; exist {
+						return fmt.Errorf("%s: %s handler already exists", t.Name, method)
+					}
+				}
+				for method, h := range add {
+					existing[method] = h
+				}
+				return nil
+			}
 		}
-		t.Child = append(t.Child, found)
-		sort.Sort(byName(t.Child))
+		// The root of a Domain holds a NotFound placeholder until
+		// a handler is registered for it
+		if t.Leaf != nil && !isNotFound(t.Leaf) {
+			return fmt.Errorf("%s: leaf already exists", t.Name)
+		}
+		t.Leaf = leaf
+		return nil
+	}
+
+	found, err := t.child(paths[0], len(paths) == 1)
+	if err != nil {
+		return t.wrap(err)
 	}
 
 	// Insert the leaf node
 	if err := found.Insert(paths[1:], leaf); err != nil {
-		if t.Name == "" {
-			return err
-		}
-		return fmt.Errorf("%s: %s", t.Name, err)
+		return t.wrap(err)
 	}
 
 	return nil
 }
 
+// Replace stores the given handler in the trie at the given path, replacing
+// any handler which was already there, and returns the previous handler
+// (if any).  An error is returned only if a wildcard in the path conflicts
+// with an existing one.
+func (t *Trie) Replace(paths []string, leaf http.Handler) (http.Handler, error) {
+	if len(paths) == 0 {
+		old := t.Leaf
+		t.Leaf = leaf
+		return old, nil
+	}
+
+	found, err := t.child(paths[0], len(paths) == 1)
+	if err != nil {
+		return nil, t.wrap(err)
+	}
+
+	old, err := found.Replace(paths[1:], leaf)
+	if err != nil {
+		return nil, t.wrap(err)
+	}
+	return old, nil
+}
+
+// Remove removes the handler at exactly the given path and returns it.
+// Any tries which are left with no handlers and no children are pruned.
+// An error is returned if there is no handler at the path.
+func (t *Trie) Remove(paths []string) (http.Handler, error) {
+	if len(paths) == 0 {
+		if t.Leaf == nil {
+			return nil, fmt.Errorf("%s: no leaf exists", t.Name)
+		}
+		old := t.Leaf
+		t.Leaf = nil
+		return old, nil
+	}
+
+	// Wildcards are removed by name, just like literal pieces
+	next, children := paths[0], &t.Child
+	if name, _ := wildcard(next); name != "" {
+		children = &t.Wild
+	}
+	for i, child := range *children {
+		if child.Name != next {
+			continue
+		}
+		old, err := child.Remove(paths[1:])
+		if err != nil {
+			return nil, t.wrap(err)
+		}
+		if child.empty() {
+			*children = append((*children)[:i:i], (*children)[i+1:]...)
+		}
+		return old, nil
+	}
+	return nil, t.wrap(fmt.Errorf("%s: no leaf exists", next))
+}
+
+// empty returns true if the trie has no handlers, middleware or children.
+func (t *Trie) empty() bool {
+	return t.Leaf == nil && len(t.Child) == 0 && len(t.Wild) == 0 && len(t.Middleware) == 0
+}
+
+// Clone returns a deep copy of the trie, so that one can be modified without
+// affecting the other.  Leaves which are themselves tries (Domain) or which
+// can have handlers added to them (Methods) are copied as well; all other
+// handlers are shared.
+func (t *Trie) Clone() *Trie {
+	clone := &Trie{
+		Name:       t.Name,
+		Leaf:       cloneLeaf(t.Leaf),
+		Policy:     t.Policy,
+		Middleware: append([]Middleware(nil), t.Middleware...),
+		built:      t.built,
+	}
+	if len(t.Child) > 0 {
+		clone.Child = make([]*Trie, len(t.Child))
+		for i, child := range t.Child {
+			clone.Child[i] = child.Clone()
+		}
+	}
+	if len(t.Wild) > 0 {
+		clone.Wild = make([]*Trie, len(t.Wild))
+		for i, child := range t.Wild {
+			clone.Wild[i] = child.Clone()
+		}
+	}
+	return clone
+}
+
+func cloneLeaf(leaf http.Handler) http.Handler {
+	switch leaf := leaf.(type) {
+	case *Domain:
+		d := *leaf
+		d.Trie = *leaf.Trie.Clone()
+		return &d
+	case Methods:
+		m := make(Methods, len(leaf))
+		for method, h := range leaf {
+			m[method] = h
+		}
+		return m
+	}
+	return leaf
+}
+
+// Methods is a handler which chooses a handler based on the request method.
+// A Trie which has Methods as its Leaf can have handlers for additional
+// methods inserted into it.
+//
+// If there is no handler for HEAD, the handler for GET is used.  If there is
+// no handler for OPTIONS, the allowed methods are served in an Allow header.
+// Otherwise, requests with a method that has no handler are served
+// 405 Method Not Allowed.
+type Methods map[string]http.Handler
+
+// Allow returns the comma-separated list of methods which are allowed.
+func (m Methods) Allow() string {
+	allow := []string{"OPTIONS"}
+	for method := range m {
+		if method != "OPTIONS" {
+			allow = append(allow, method)
+		}
+	}
+	if _, ok := m["GET"]; ok {
+		if _, ok := m["HEAD"]; !ok {
+			allow = append(allow, "HEAD")
+		}
+	}
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+// ServeHTTP serves the handler for the request method.
+func (m Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m[r.Method]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "HEAD":
+		if h, ok := m["GET"]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+	case "OPTIONS":
+		w.Header().Set("Allow", m.Allow())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Allow", m.Allow())
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
 // Domain serves the trie for a specific domain.
 //
 // Domains are the handlers registered for each domain
 // within a ServeMux.
 type Domain struct {
 	Trie
+
+	// Policy controls redirects when the Domain is served directly.
+	// Within a ServeMux, the ServeMux's Policy is used instead.
+	Policy Policy
 }
 
 // NewDomain creates a new Domain with no handlers registered.
@@ -145,54 +559,157 @@ func NewDomain() *Domain {
 
 // ServeHTTP finds and serves the appropriate handler for the path.
 func (d *Domain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, r := d.lookup(r, &d.Policy, nil)
+	h.ServeHTTP(w, r)
+}
+
+// lookup returns the handler for the request along with the request
+// it should be served (which includes any parameters).  Redirects are
+// issued according to the node's policy or the given default.  If trace
+// is non-nil, the decisions made are recorded in it.
+func (d *Domain) lookup(r *http.Request, policy *Policy, trace *Trace) (http.Handler, *http.Request) {
+	h, r := d.route(r, policy, trace)
+	return wrap(h, d.chain(nil), "domain", trace), r
+}
+
+// route is like lookup, but without the domain's own middleware.
+func (d *Domain) route(r *http.Request, policy *Policy, trace *Trace) (http.Handler, *http.Request) {
 	cleaned := pathpkg.Clean(r.URL.Path)
-	if strings.HasSuffix(r.URL.Path, "/") {
+	if strings.HasSuffix(r.URL.Path, "/") && cleaned != "/" {
 		cleaned += "/"
 	}
 
 	// Redirect if the cleaned path differs
 	if r.URL.Path != cleaned {
-		rewrite(w, r, cleaned)
-		return
+		if trace != nil {
+			trace.add("path %q is not clean (%q)", r.URL.Path, cleaned)
+		}
+		return rewrite(r, cleaned, policy.cleanCode()), r
 	}
 
 	// Find the best handler
 	paths := vaccuum(strings.SplitAfter(r.URL.Path, "/")[1:])
-	n, found := d.Find(paths)
+	n, found, params, chains := d.match(paths, nil, nil)
+	if trace != nil {
+		trace.add("path %q matched %q (%d of %d pieces)", r.URL.Path, "/"+strings.Join(paths[:n], ""), n, len(paths))
+		for _, p := range params {
+			trace.add("parameter %q = %q", p.Name, p.Value)
+		}
+		trace.Params = append(trace.Params, params...)
+	}
+	if found.Policy != nil {
+		policy = found.Policy
+	}
+
+	// Handle requests which differ from a pattern by a trailing slash
+	if _, ok := found.Leaf.(slashRedirect); ok {
+		return d.slash(r, paths, found, policy, trace)
+	}
 
 	if n != len(paths) && !strings.HasSuffix(found.Name, "/") {
-		http.NotFound(w, r)
-		return
+		if trace != nil {
+			trace.add("partial match is not a directory")
+		}
+		return http.HandlerFunc(http.NotFound), r
 	}
 
-	found.Leaf.ServeHTTP(w, r)
+	// Middleware for a directory applies to every request beneath it, even
+	// one which is routed to the handler of an enclosing directory
+	chains = merge(chains, d.enclosing(paths))
+
+	// Canonicalize directories without their trailing slash if requested
+	if policy.slash() == StripSlash && n == len(paths) && n > 0 && strings.HasSuffix(found.Name, "/") {
+		if an, alias, _ := d.Match(slashless(paths)); an == n {
+			if isAlias(alias.Leaf) {
+				if trace != nil {
+					trace.add("trailing slash policy %q strips the slash", policy.slash())
+				}
+				return slashRedirect{}.strip(r, policy.slashCode()), r
+			}
+		}
+	}
+
+	h, r := serve(found.Leaf, withParams(r, params), paths[:n], trace)
+	return wrap(h, chains, "path", trace), r
+}
+
+// slash returns the handler for a request which matched the trailing slash
+// alias of a pattern (found).
+func (d *Domain) slash(r *http.Request, paths []string, found *Trie, policy *Policy, trace *Trace) (http.Handler, *http.Request) {
+	dir := !strings.HasSuffix(found.Name, "/")
+	mode := policy.slash()
+	if trace != nil {
+		trace.add("trailing slash policy %q applies to %q", mode, found.Name)
+	}
+
+	switch {
+	case mode == RedirectSlash && dir:
+		return slashRedirect{}.add(r, policy.slashCode()), r
+	case mode == StripSlash && !dir:
+		return slashRedirect{}.strip(r, policy.slashCode()), r
+	case mode == RedirectSlash:
+		return http.HandlerFunc(http.NotFound), r
+	}
+
+	// Serve the handler for the pattern itself
+	target := slashless(paths)
+	if dir {
+		target[len(target)-1] += "/"
+	}
+	n, pattern, params, chains := d.match(target, nil, nil)
+	if n != len(target) || pattern.Leaf == nil {
+		return http.HandlerFunc(http.NotFound), r
+	}
+	if trace != nil {
+		trace.add("serving %q", "/"+strings.Join(target, ""))
+	}
+	h, r := serve(pattern.Leaf, withParams(r, params), target, trace)
+	return wrap(h, chains, "path", trace), r
 }
 
 // ServeMux serves the tries for all configured domains.
+//
+// If a request is for a subdomain of a registered domain, the client
+// is normally redirected to the registered domain.  If ServePartial
+// is set, the request is instead served by the registered domain.
 type ServeMux struct {
 	Trie
+	ServePartial bool
+	Policy       Policy // redirect policy for all domains
 }
 
 // Handle registers the given handler to be called on requests matching the
 // given pattern.  In general, the pattern takes the following form:
 //   <domain>/<path>
 //
-// Both the domain and the path portions are optional.
+// Both the domain and the path portions are optional.  The path may contain
+// wildcard pieces (see Trie), the values of which are available to the
+// handler from RequestParams:
+//   /go/{pkg}/...
+//   /{year}/{month}/{slug}
+//
+// The pattern may be preceded by a method and a space, in which case the
+// handler is only used for requests with that method (see Methods):
+//   GET /api/items
+//   POST example.com/api/items
+//
+// The domain is matched without regard to case, trailing dots or port.
+// Internationalized domains may be given in either Unicode or punycode.
+// A port may be specified to register handlers which are only used for
+// requests to that port:
+//   example.com:8080/
+//
+// A label in the domain may be "*" (or a {name} parameter) to match any
+// single label.  The matched label is available from RequestParams
+// under the name "*" (or the parameter name):
+//   *.example.com/
+//   {user}.example.com/
 func (s *ServeMux) Handle(pattern string, handler http.Handler) {
-	// Split the pattern
-	pieces := strings.SplitAfter(pattern, "/")
-	if len(pieces) < 2 {
-		panic(fmt.Sprintf("handle pattern %q is not in <domain>/<path> form", pattern))
+	method, domain, path := parsePattern(pattern)
+	if method != "" {
+		handler = Methods{method: handler}
 	}
 
-	// Break down the domain and strip empties from the ends
-	pieces[0] = strings.TrimSuffix(pieces[0], "/")
-	domain := vaccuum(strings.Split(pieces[0], "."))
-	reverse(domain)
-
-	// Grab the rest of the pieces as the path and strip empties
-	path := vaccuum(pieces[1:])
-
 	// Helper for inserting at the path and its index if applicable
 	insert := func(t *Trie) {
 		if err := t.Insert(path, handler); err != nil {
@@ -200,14 +717,13 @@ func (s *ServeMux) Handle(pattern string, handler http.Handler) {
 		}
 		if strings.HasSuffix(pattern, "/") {
 			// we don't care if this already exists
-			path[len(path)-1] = strings.TrimSuffix(path[len(path)-1], "/")
-			_ = t.Insert(path, http.HandlerFunc(addSlash))
+			_ = t.Insert(slashless(path), slashRedirect{})
 		}
 	}
 
 	// Find domain
-	n, found := s.Find(domain)
-	if n != len(domain) {
+	found := s.exact(domain)
+	if found == nil || found.Leaf == nil {
 		d := NewDomain()
 		insert(&d.Trie)
 		s.Insert(domain, d)
@@ -216,6 +732,152 @@ func (s *ServeMux) Handle(pattern string, handler http.Handler) {
 	insert(&found.Leaf.(*Domain).Trie)
 }
 
+// Replace is like Handle, but it replaces any handler which is already
+// registered for the pattern instead of panicking.  If the pattern has a
+// method, only the handler for that method is replaced.
+func (s *ServeMux) Replace(pattern string, handler http.Handler) {
+	method, domain, path := parsePattern(pattern)
+
+	found := s.exact(domain)
+	if found == nil || found.Leaf == nil {
+		if _, err := s.Trie.Replace(domain, NewDomain()); err != nil {
+			panic(err)
+		}
+		found = s.exact(domain)
+	}
+	d := &found.Leaf.(*Domain).Trie
+
+	if method != "" {
+		m := Methods{}
+		if leaf := d.exact(path); leaf != nil {
+			if existing, ok := leaf.Leaf.(Methods); ok {
+				m = cloneLeaf(existing).(Methods)
+			}
+		}
+		m[method] = handler
+		handler = m
+	}
+	if _, err := d.Replace(path, handler); err != nil {
+		panic(err)
+	}
+	if strings.HasSuffix(pattern, "/") {
+		// we don't care if this already exists
+		_ = d.Insert(slashless(path), slashRedirect{})
+	}
+}
+
+// Remove unregisters the handler for the given pattern, which must
+// be the same as it was when registered.  If the pattern has a method,
+// only the handler for that method is removed.  An error is returned
+// if no handler was registered for the pattern.
+//
+// Domains which are left with no handlers are removed.
+func (s *ServeMux) Remove(pattern string) error {
+	method, domain, path := parsePattern(pattern)
+
+	found := s.exact(domain)
+	if found == nil || found.Leaf == nil {
+		return fmt.Errorf("%s: no handlers registered", pattern)
+	}
+	d := &found.Leaf.(*Domain).Trie
+
+	if method != "" {
+		leaf := d.exact(path)
+		if leaf == nil {
+			return fmt.Errorf("%s: no handler registered", pattern)
+		}
+		m, ok := leaf.Leaf.(Methods)
+		if _, exist := m[method]; !ok || !exist {
+			return fmt.Errorf("%s: no %s handler registered", pattern, method)
+		}
+		if len(m) > 1 {
+			m = cloneLeaf(m).(Methods)
+			delete(m, method)
+			d.Replace(path, m)
+			return nil
+		}
+	}
+
+	if len(path) == 0 {
+		// The root of a domain always has a handler
+		if isNotFound(d.Leaf) {
+			return fmt.Errorf("%s: no handler registered", pattern)
+		}
+		d.Leaf = http.HandlerFunc(http.NotFound)
+	} else if _, err := d.Remove(path); err != nil {
+		return fmt.Errorf("%s: no handler registered", pattern)
+	}
+	if node := d.exact(path); node != nil {
+		node.Policy = nil
+	}
+
+	// Remove the trailing slash alias along with the directory (or file)
+	if len(path) > 0 {
+		alias := slashless(path)
+		if !strings.HasSuffix(pattern, "/") {
+			alias[len(alias)-1] += "/"
+		}
+		if leaf := d.exact(alias); leaf != nil && isAlias(leaf.Leaf) {
+			d.Remove(alias)
+		}
+	}
+
+	// Remove the domain if it is empty (the default domain is always kept)
+	if len(domain) > 0 && len(d.Child) == 0 && len(d.Wild) == 0 && len(d.Middleware) == 0 && isNotFound(d.Leaf) {
+		s.Trie.Remove(domain)
+	}
+	return nil
+}
+
+// isNotFound returns true if h is the default handler for the root of a Domain.
+func isNotFound(h http.Handler) bool {
+	f, ok := h.(http.HandlerFunc)
+	return ok && reflect.ValueOf(f).Pointer() == reflect.ValueOf(http.NotFound).Pointer()
+}
+
+// parsePattern splits a pattern (see Handle) into its method (if any),
+// its reversed domain labels and its path pieces.
+func parsePattern(pattern string) (method string, domain, path []string) {
+	// Split off the method
+	if sp := strings.Index(pattern, " "); sp >= 0 {
+		method = pattern[:sp]
+		pattern = strings.TrimLeft(pattern[sp:], " ")
+		if method == "" || strings.ToUpper(method) != method {
+			panic(fmt.Sprintf("handle pattern has invalid method %q", method))
+		}
+	}
+
+	// Split the pattern
+	pieces := strings.SplitAfter(pattern, "/")
+	if len(pieces) < 2 {
+		panic(fmt.Sprintf("handle pattern %q is not in <domain>/<path> form", pattern))
+	}
+
+	// Break down the domain (and port) and strip empties from the ends
+	domain, port := hostLabels(strings.TrimSuffix(pieces[0], "/"))
+	for i, label := range domain {
+		if label == "*" {
+			domain[i] = "{*}"
+		}
+	}
+	if port != "" {
+		domain = append([]string{":" + port}, domain...)
+	}
+
+	// Grab the rest of the pieces as the path and strip empties
+	return method, domain, vaccuum(pieces[1:])
+}
+
+// slashless returns a copy of the path with the trailing slash removed
+// from its final piece.
+func slashless(path []string) []string {
+	path = append([]string(nil), path...)
+	if len(path) > 0 {
+		path[len(path)-1] = strings.TrimSuffix(path[len(path)-1], "/")
+	}
+	return path
+}
+
 // NewServeMux creates a new ServeMux with no handlers registered.
 func NewServeMux() *ServeMux {
 	return &ServeMux{
@@ -232,40 +894,121 @@ func (s *ServeMux) HandleFunc(pattern string, handler func(http.ResponseWriter,
 
 // ServeHTTP finds the most appropriate domain handler and serves it.
 func (s *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, r := s.lookup(r, nil)
+	h.ServeHTTP(w, r)
+}
+
+// lookup is like Domain.lookup, but it also chooses the domain.
+func (s *ServeMux) lookup(r *http.Request, trace *Trace) (http.Handler, *http.Request) {
+	h, r := s.route(r, trace)
+	return wrap(h, s.chain(nil), "global", trace), r
+}
+
+// route is like lookup, but without the global middleware.
+func (s *ServeMux) route(r *http.Request, trace *Trace) (http.Handler, *http.Request) {
 	// Find the best handler
-	domain := strings.Split(r.Host, ".")
-	reverse(domain)
-	n, found := s.Find(domain)
+	domain, port := hostLabels(r.Host)
+	n, found, params := s.Match(domain)
+	if trace != nil {
+		trace.add("host %q normalized to %q (port %q)", r.Host, domainName(domain), port)
+	}
+
+	// Prefer a port-specific domain if it matches at least as well
+	if port != "" {
+		pn, pfound, pparams := s.Match(append([]string{":" + port}, domain...))
+		if pn > 0 && pn-1 >= n {
+			n, found, params = pn-1, pfound, pparams
+			if trace != nil {
+				trace.add("port-specific domain matches")
+			}
+		}
+	}
+	if trace != nil {
+		trace.add("domain matched %q (%d of %d labels)", domainName(domain[:n]), n, len(domain))
+	}
+
 	if n > 0 && n != len(domain) {
-		domain = domain[:n]
-		reverse(domain)
-		changeHost(w, r, strings.Join(domain, "."))
-		return
+		if !s.ServePartial {
+			domain = domain[:n]
+			reverse(domain)
+			host := strings.Join(domain, ".")
+			if port != "" {
+				host = net.JoinHostPort(host, port)
+			}
+			if trace != nil {
+				trace.add("partial domain match changes host to %q", host)
+			}
+			return changeHost(r, host, s.Policy.hostCode()), r
+		}
+		if trace != nil {
+			trace.add("partial domain match is served (ServePartial)")
+		}
 	}
-	found.Leaf.ServeHTTP(w, r)
+	if trace != nil {
+		trace.Params = append(trace.Params, params...)
+	}
+
+	r = withParams(r, params)
+	if d, ok := found.Leaf.(*Domain); ok {
+		return d.lookup(r, &s.Policy, trace)
+	}
+	return found.Leaf, r
+}
+
+// A redirect is a handler which redirects to a fixed URL.
+type redirect struct {
+	url  string
+	code int
+}
+
+func (h redirect) String() string {
+	return fmt.Sprintf("redirect %d to %s", h.code, h.url)
+}
+
+func (h redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, h.url, h.code)
 }
 
-// changeHost emits a redirect to the same path but with the given host.
-func changeHost(w http.ResponseWriter, r *http.Request, host string) {
+// changeHost returns a redirect to the same path but with the given host.
+func changeHost(r *http.Request, host string, code int) http.Handler {
 	u := *r.URL
 	u.Host = host
 	u.Scheme = "http"
 	if r.TLS != nil {
 		u.Scheme = "https"
 	}
-	http.Redirect(w, r, u.String(), http.StatusFound)
+	return redirect{u.String(), code}
+}
+
+// slashRedirect is registered for the path without the trailing slash
+// when a directory is registered (and vice versa for files with some
+// policies).  Domain consults its policy when it is matched; when served
+// directly, it redirects to add a trailing slash.
+type slashRedirect struct{}
+
+func (slashRedirect) String() string { return "add trailing slash" }
+
+func (h slashRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.add(r, http.StatusFound).ServeHTTP(w, r)
 }
 
-// addSlash emits a redirect to the same path but with a trailing slash.
-func addSlash(w http.ResponseWriter, r *http.Request) {
+// add returns a redirect to the same path but with a trailing slash.
+func (slashRedirect) add(r *http.Request, code int) http.Handler {
 	u := *r.URL
 	u.Path += "/"
-	http.Redirect(w, r, u.String(), http.StatusFound)
+	return redirect{u.String(), code}
+}
+
+// strip returns a redirect to the same path but without a trailing slash.
+func (slashRedirect) strip(r *http.Request, code int) http.Handler {
+	u := *r.URL
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return redirect{u.String(), code}
 }
 
-// rewrite emits a redirect to the given path with 301 Moved Permanently.
-func rewrite(w http.ResponseWriter, r *http.Request, path string) {
+// rewrite returns a redirect to the given path.
+func rewrite(r *http.Request, path string, code int) http.Handler {
 	u := *r.URL
 	u.Path = path
-	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	return redirect{u.String(), code}
 }
@@ -143,6 +143,290 @@ func TestFind(t *testing.T) {
 	}
 }
 
+func TestRemove(t *testing.T) {
+	trie := &Trie{Leaf: textHandler("/")}
+	for _, path := range [][]string{
+		{"foo/", "bar"},
+		{"foo/", "baz"},
+		{"foo/", "{id}/", "edit"},
+	} {
+		if err := trie.Insert(path, textHandler(strings.Join(path, ""))); err != nil {
+			t.Fatalf("Insert(%q): %s", path, err)
+		}
+	}
+
+	tests := []struct {
+		paths []string
+		leaf  http.Handler
+		err   string
+		after *Trie
+	}{{
+		paths: []string{"foo/", "qux"},
+		err:   "foo/: qux: no leaf exists",
+	}, {
+		paths: []string{"foo/"},
+		err:   "foo/: no leaf exists",
+	}, {
+		paths: []string{"foo/", "{id}/", "edit"},
+		leaf:  textHandler("foo/{id}/edit"),
+		after: &Trie{
+			Child: []*Trie{{
+				Name: "foo/",
+				Child: []*Trie{{
+					Name: "bar",
+					Leaf: textHandler("foo/bar"),
+				}, {
+					Name: "baz",
+					Leaf: textHandler("foo/baz"),
+				}},
+			}},
+			Leaf: textHandler("/"),
+		},
+	}, {
+		paths: []string{"foo/", "bar"},
+		leaf:  textHandler("foo/bar"),
+		after: &Trie{
+			Child: []*Trie{{
+				Name: "foo/",
+				Child: []*Trie{{
+					Name: "baz",
+					Leaf: textHandler("foo/baz"),
+				}},
+			}},
+			Leaf: textHandler("/"),
+		},
+	}, {
+		paths: []string{"foo/", "baz"},
+		leaf:  textHandler("foo/baz"),
+		after: &Trie{
+			Leaf: textHandler("/"),
+		},
+	}}
+
+	for idx, test := range tests {
+		leaf, err := trie.Remove(test.paths)
+		if err != nil || test.err != "" {
+			if got, want := fmt.Sprint(err), test.err; got != want {
+				t.Errorf("%d. Remove(%q): %s, want %s", idx, test.paths, got, want)
+			}
+			continue
+		}
+		if got, want := leaf, test.leaf; got != want {
+			t.Errorf("%d. Remove(%q) = %v, want %v", idx, test.paths, got, want)
+		}
+		if cmp := pretty.Compare(trie, test.after); cmp != "" {
+			t.Errorf("%d. after Remove(%q):\n%s", idx, test.paths, cmp)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	trie := &Trie{}
+	if old, err := trie.Replace([]string{"foo"}, textHandler("one")); err != nil || old != nil {
+		t.Errorf("Replace = %v, %v, want <nil>, <nil>", old, err)
+	}
+	if old, err := trie.Replace([]string{"foo"}, textHandler("two")); err != nil || old != textHandler("one") {
+		t.Errorf("Replace = %v, %v, want %q, <nil>", old, err, "one")
+	}
+	if _, err := trie.Replace([]string{"{a}", "x"}, textHandler("a")); err != nil {
+		t.Errorf("Replace({a}): %s", err)
+	}
+	if _, err := trie.Replace([]string{"{b}", "x"}, textHandler("b")); err == nil {
+		t.Errorf("Replace({b}) succeeded, want conflict")
+	}
+	if _, found := trie.Find([]string{"foo"}); found.Leaf != textHandler("two") {
+		t.Errorf("Find(foo) = %v, want %q", found.Leaf, "two")
+	}
+}
+
+func TestServeMuxRemove(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("/dir/", textHandler("/dir/ index"))
+	mux.Handle("/foo", textHandler("/foo handler"))
+	mux.Handle("GET /api", textHandler("get"))
+	mux.Handle("POST /api", textHandler("post"))
+	mux.Handle("example.com/foo", textHandler("example.com/foo handler"))
+
+	for _, pattern := range []string{"/dir/", "GET /api", "example.com/foo"} {
+		if err := mux.Remove(pattern); err != nil {
+			t.Errorf("Remove(%q): %s", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"/dir/", "/bar", "GET /api", "/foo/", "example.com/foo"} {
+		if err := mux.Remove(pattern); err == nil {
+			t.Errorf("Remove(%q) succeeded again", pattern)
+		}
+	}
+	mux.Replace("/foo", textHandler("/foo replaced"))
+	mux.Replace("POST /api", textHandler("post replaced"))
+	mux.Replace("example.com/", textHandler("example.com root"))
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+		body   string
+	}{
+		{"GET", "/dir", 404, "404 page not found\n"},
+		{"GET", "/dir/", 404, "404 page not found\n"},
+		{"GET", "/foo", 200, "/foo replaced"},
+		{"GET", "/api", 405, "Method Not Allowed\n"},
+		{"POST", "/api", 200, "post replaced"},
+		{"GET", "http://example.com/", 200, "example.com root"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, test.method, test.url))
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("%s %q: %d %s, want %d %s", test.method, test.url,
+				got, http.StatusText(got), want, http.StatusText(want))
+		}
+		if got, want := w.Body.String(), test.body; got != want {
+			t.Errorf("%s %q: body = %q, want %q", test.method, test.url, got, want)
+		}
+	}
+
+	if err := mux.Remove("example.com/"); err != nil {
+		t.Errorf("Remove(%q): %s", "example.com/", err)
+	}
+	if found := mux.exact([]string{"com", "example"}); found != nil {
+		t.Errorf("empty domain was not removed: %v", found)
+	}
+}
+
+func TestInsertWildcard(t *testing.T) {
+	tests := []struct {
+		desc     string
+		existing [][]string
+		paths    []string
+		err      string
+	}{
+		{
+			desc:     "same parameter",
+			existing: [][]string{{"{a}/", "x"}},
+			paths:    []string{"{a}/", "y"},
+		},
+		{
+			desc:     "file and dir parameters",
+			existing: [][]string{{"{a}/", "x"}},
+			paths:    []string{"{b}"},
+		},
+		{
+			desc:     "parameter and catch-all",
+			existing: [][]string{{"{a}/", "x"}},
+			paths:    []string{"..."},
+		},
+		{
+			desc:     "conflicting parameters",
+			existing: [][]string{{"{a}/", "x"}},
+			paths:    []string{"{b}/", "y"},
+			err:      "{b}/: conflicts with {a}/",
+		},
+		{
+			desc:     "conflicting catch-alls",
+			existing: [][]string{{"go/", "..."}},
+			paths:    []string{"go/", "{rest...}"},
+			err:      "go/: {rest...}: conflicts with ...",
+		},
+		{
+			desc:  "catch-all not last",
+			paths: []string{"...", "x"},
+			err:   "...: catch-all must be the final piece",
+		},
+	}
+
+	for _, test := range tests {
+		trie := NewDomain().Trie
+		for _, paths := range test.existing {
+			if err := trie.Insert(paths, textHandler("existing")); err != nil {
+				t.Fatalf("%s: insert(%q): %s", test.desc, paths, err)
+			}
+		}
+		var got string
+		if err := trie.Insert(test.paths, textHandler("new")); err != nil {
+			got = err.Error()
+		}
+		if want := test.err; got != want {
+			t.Errorf("%s: insert(%q) = %q, want %q", test.desc, test.paths, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	trie := NewDomain().Trie
+	for _, paths := range [][]string{
+		{"go/", "rx"},
+		{"go/", "{pkg}/", "..."},
+		{"go/", "{pkg}"},
+		{"{year}/", "{month}/", "{slug}"},
+		{"blog/"},
+		{"files/", "{path...}"},
+	} {
+		if err := trie.Insert(paths, textHandler(strings.Join(paths, ""))); err != nil {
+			t.Fatalf("insert(%q): %s", paths, err)
+		}
+	}
+
+	tests := []struct {
+		paths  []string
+		n      int
+		leaf   textHandler
+		params Params
+	}{{
+		paths: []string{"go/", "rx"},
+		n:     2,
+		leaf:  "go/rx",
+	}, {
+		paths:  []string{"go/", "gofr"},
+		n:      2,
+		leaf:   "go/{pkg}",
+		params: Params{{"pkg", "gofr"}},
+	}, {
+		paths:  []string{"go/", "rx/"},
+		n:      2,
+		leaf:   "go/{pkg}/...",
+		params: Params{{"pkg", "rx"}, {"...", ""}},
+	}, {
+		paths:  []string{"go/", "rx/", "graph/", "dot"},
+		n:      4,
+		leaf:   "go/{pkg}/...",
+		params: Params{{"pkg", "rx"}, {"...", "graph/dot"}},
+	}, {
+		paths:  []string{"2009/", "07/", "real-id-get-real"},
+		n:      3,
+		leaf:   "{year}/{month}/{slug}",
+		params: Params{{"year", "2009"}, {"month", "07"}, {"slug", "real-id-get-real"}},
+	}, {
+		paths: []string{"blog/", "07/", "real-id-get-real"},
+		n:     1,
+		leaf:  "blog/",
+	}, {
+		paths:  []string{"files/", "a/", "b"},
+		n:      3,
+		leaf:   "files/{path...}",
+		params: Params{{"path", "a/b"}},
+	}, {
+		paths: []string{"2009/", "07/"},
+		n:     0,
+	}}
+
+	for _, test := range tests {
+		n, found, params := trie.Match(test.paths)
+		if got, want := n, test.n; got != want {
+			t.Errorf("Match(%q).n = %v, want %v", test.paths, got, want)
+		}
+		if test.leaf != "" {
+			if got, want := found.Leaf, test.leaf; got != want {
+				t.Errorf("Match(%q) found %q, want %q", test.paths, got, want)
+			}
+		}
+		if got, want := params, test.params; !reflect.DeepEqual(got, want) {
+			t.Errorf("Match(%q).params = %q, want %q", test.paths, got, want)
+		}
+	}
+}
+
 func TestHandle(t *testing.T) {
 	mux := NewServeMux()
 	tests := []struct {
@@ -189,7 +473,7 @@ func TestHandle(t *testing.T) {
 						Trie: Trie{
 							Child: []*Trie{{
 								Name: "dir",
-								Leaf: http.HandlerFunc(addSlash),
+								Leaf: slashRedirect{},
 							}, {
 								Name: "dir/",
 								Leaf: textHandler("/dir/ index"),
@@ -218,7 +502,7 @@ func TestHandle(t *testing.T) {
 										Name: "sub/",
 										Child: []*Trie{{
 											Name: "dir",
-											Leaf: http.HandlerFunc(addSlash),
+											Leaf: slashRedirect{},
 										}, {
 											Name: "dir/",
 											Leaf: textHandler("example.com/sub/dir/ index"),
@@ -233,7 +517,7 @@ func TestHandle(t *testing.T) {
 						Trie: Trie{
 							Child: []*Trie{{
 								Name: "dir",
-								Leaf: http.HandlerFunc(addSlash),
+								Leaf: slashRedirect{},
 							}, {
 								Name: "dir/",
 								Leaf: textHandler("/dir/ index"),
@@ -326,6 +610,131 @@ func TestServeHTTP(t *testing.T) {
 			code:     302,
 			redir:    "http://example.com/foo?q",
 		},
+		{
+			desc:     "domain case and port",
+			handlers: []string{"example.com/foo"},
+			req:      request(t, "GET", "http://Example.COM:8080/foo"),
+			code:     200,
+			body:     "example.com/foo handler",
+		},
+		{
+			desc:     "domain trailing dot",
+			handlers: []string{"example.com/foo"},
+			req:      request(t, "GET", "http://example.com./foo"),
+			code:     200,
+			body:     "example.com/foo handler",
+		},
+		{
+			desc:     "domain idna",
+			handlers: []string{"bücher.example/foo"},
+			req:      request(t, "GET", "http://xn--bcher-kva.example/foo"),
+			code:     200,
+			body:     "bücher.example/foo handler",
+		},
+		{
+			desc:     "domain ipv6",
+			handlers: []string{"[::1]/foo"},
+			req:      request(t, "GET", "http://[::1]:8080/foo"),
+			code:     200,
+			body:     "[::1]/foo handler",
+		},
+		{
+			desc:     "domain port",
+			handlers: []string{"example.com/foo", "example.com:8080/foo"},
+			req:      request(t, "GET", "http://example.com:8080/foo"),
+			code:     200,
+			body:     "example.com:8080/foo handler",
+		},
+		{
+			desc:     "domain other port",
+			handlers: []string{"example.com/foo", "example.com:8080/foo"},
+			req:      request(t, "GET", "http://example.com:8443/foo"),
+			code:     200,
+			body:     "example.com/foo handler",
+		},
+		{
+			desc:     "sub domain port",
+			handlers: []string{"example.com/foo"},
+			req:      request(t, "GET", "http://www.example.com:8080/foo"),
+			code:     302,
+			redir:    "http://example.com:8080/foo",
+		},
+		{
+			desc:     "wildcard domain",
+			handlers: []string{"*.example.com/foo"},
+			req:      request(t, "GET", "http://www.example.com/foo"),
+			code:     200,
+			body:     "*.example.com/foo handler",
+		},
+		{
+			desc:     "wildcard domain literal",
+			handlers: []string{"*.example.com/foo", "www.example.com/foo"},
+			req:      request(t, "GET", "http://www.example.com/foo"),
+			code:     200,
+			body:     "www.example.com/foo handler",
+		},
+		{
+			desc:     "wildcard domain base",
+			handlers: []string{"*.example.com/foo"},
+			req:      request(t, "GET", "http://example.com/foo"),
+			code:     404,
+		},
+		{
+			desc:     "wildcard domain sub domain",
+			handlers: []string{"*.example.com/foo"},
+			req:      request(t, "GET", "http://a.b.example.com/foo"),
+			code:     302,
+			redir:    "http://b.example.com/foo",
+		},
+		{
+			desc:     "root",
+			handlers: []string{"/"},
+			req:      request(t, "GET", "http://example.com/"),
+			code:     200,
+			body:     "/ handler",
+		},
+		{
+			desc:     "catch-all root",
+			handlers: []string{"/{path...}"},
+			req:      request(t, "GET", "http://example.com/"),
+			code:     200,
+			body:     "/{path...} handler",
+		},
+		{
+			desc:     "anonymous catch-all root",
+			handlers: []string{"/..."},
+			req:      request(t, "GET", "http://example.com/"),
+			code:     200,
+			body:     "/... handler",
+		},
+		{
+			desc:     "catch-all with root handler",
+			handlers: []string{"/", "/{path...}"},
+			req:      request(t, "GET", "http://example.com/"),
+			code:     200,
+			body:     "/ handler",
+		},
+		{
+			desc:     "wildcard domain root",
+			handlers: []string{"example.com/", "*.example.com/"},
+			req:      request(t, "GET", "http://www.example.com/"),
+			code:     200,
+			body:     "*.example.com/ handler",
+		},
+		{
+			desc:     "wildcard domain root base",
+			handlers: []string{"example.com/", "*.example.com/"},
+			req:      request(t, "GET", "http://example.com/"),
+			code:     200,
+			body:     "example.com/ handler",
+		},
+		{
+			desc:     "parameter domain root",
+			handlers: []string{"{user}.example.com/"},
+			req:      request(t, "GET", "http://alice.example.com/"),
+			code:     200,
+			body:     "{user}.example.com/ handler",
+		},
 		{
 			desc:     "dir",
 			handlers: []string{"/dir/"},
@@ -374,6 +783,19 @@ func TestServeHTTP(t *testing.T) {
 			code:     301,
 			redir:    "/foo/bar",
 		},
+		{
+			desc:     "clean root",
+			handlers: []string{"/foo"},
+			req:      request(t, "GET", "/"),
+			code:     404,
+		},
+		{
+			desc:     "clean to root",
+			handlers: []string{"/"},
+			req:      request(t, "GET", "/foo/..//"),
+			code:     301,
+			redir:    "/",
+		},
 		{
 			desc:     "clean query",
 			handlers: []string{"/foo/bar"},
@@ -407,6 +829,83 @@ func TestServeHTTP(t *testing.T) {
 	}
 }
 
+func TestMethods(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("GET /api/items", textHandler("list"))
+	mux.Handle("POST /api/items", textHandler("create"))
+	mux.Handle("/api/other", textHandler("other"))
+
+	func() {
+		defer func() {
+			if got, want := fmt.Sprint(recover()), "api/: items: GET handler already exists"; got != want {
+				t.Errorf("duplicate Handle: %q, want %q", got, want)
+			}
+		}()
+		mux.Handle("GET /api/items", textHandler("list 2"))
+	}()
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+		allow  string
+	}{
+		{"GET", "/api/items", 200, "list", ""},
+		{"HEAD", "/api/items", 200, "list", ""},
+		{"POST", "/api/items", 200, "create", ""},
+		{"DELETE", "/api/items", 405, "Method Not Allowed\n", "GET, HEAD, OPTIONS, POST"},
+		{"OPTIONS", "/api/items", 204, "", "GET, HEAD, OPTIONS, POST"},
+		{"DELETE", "/api/other", 200, "other", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, test.method, test.path))
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("%s %q: %d %s, want %d %s", test.method, test.path,
+				got, http.StatusText(got), want, http.StatusText(want))
+		}
+		if got, want := w.Body.String(), test.body; got != want {
+			t.Errorf("%s %q: body = %q, want %q", test.method, test.path, got, want)
+		}
+		if got, want := w.HeaderMap.Get("Allow"), test.allow; got != want {
+			t.Errorf("%s %q: Allow = %q, want %q", test.method, test.path, got, want)
+		}
+	}
+}
+
+func TestServeParams(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/{year}/{month}/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		p := RequestParams(r)
+		fmt.Fprintf(w, "%s-%s-%s", p.Get("year"), p.Get("month"), p.Get("slug"))
+	})
+
+	mux.HandleFunc("*.example.com/{page}", func(w http.ResponseWriter, r *http.Request) {
+		p := RequestParams(r)
+		fmt.Fprintf(w, "%s-%s", p.Get("*"), p.Get("page"))
+	})
+	mux.ServePartial = true
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"/2011/11/generic-types-in-go", "2011-11-generic-types-in-go"},
+		{"http://blog.example.com/about", "blog-about"},
+		{"http://www.blog.example.com/about", "blog-about"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request(t, "GET", test.url))
+		if got, want := w.Body.String(), test.body; got != want {
+			t.Errorf("GET %q: body = %q, want %q", test.url, got, want)
+		}
+	}
+}
+
 func perms(length int, f func([]int)) {
 	idx := make([]int, length)
 	for i := range idx {
@@ -544,3 +1043,14 @@ func BenchmarkHTTPServeMux5(b *testing.B) { benchMux(b, 5, http.NewServeMux()) }
 //func BenchmarkHTTPServeMux6(b *testing.B) { benchMux(b, 6, http.NewServeMux()) }
 //func BenchmarkTrieServeMux7(b *testing.B) { benchMux(b, 7, NewServeMux()) }
 //func BenchmarkHTTPServeMux7(b *testing.B) { benchMux(b, 7, http.NewServeMux()) }
+
+func TestHandleRootTwice(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("example.com/", textHandler("first"))
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering example.com/ twice did not panic")
+		}
+	}()
+	mux.Handle("example.com/", textHandler("second"))
+}